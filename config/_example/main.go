@@ -45,7 +45,7 @@ func main() {
 		log.Println("Addr changed todo something by", new)
 	})
 
-	log.Println("change complete", config.Addr)
+	log.Println("change complete", cfg.GetConfig().(*Config).Addr)
 
 	time.Sleep(time.Hour)
 }
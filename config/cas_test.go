@@ -0,0 +1,46 @@
+package config
+
+import "testing"
+
+//casBackend save the config only if the revision is not moved after it is loaded
+type casBackend struct {
+	cfg      interface{}
+	loaded   int64
+	revision int64
+}
+
+func (b *casBackend) LoadConfig(o Options) error {
+	if b.cfg == nil {
+		b.cfg, b.revision = o.NewConfig(), 1
+	}
+	b.loaded = b.revision
+	return o.OnLoaded(cloneInstance(b.cfg))
+}
+
+func (b *casBackend) SaveConfig(o Options, cfg interface{}) error {
+	if b.loaded != b.revision || (o.Revision != 0 && o.Revision != b.loaded) {
+		return &ErrConflict{Path: o.URL, Revision: b.loaded}
+	}
+	b.cfg = cloneInstance(cfg)
+	b.revision++
+	b.loaded = b.revision
+	return nil
+}
+
+func (b *casBackend) Revision() int64 {
+	return b.loaded
+}
+
+//initCas init the config from the cas backend b, a new backend is used if b is nil,
+//the default options are the url cas://test, the default config testKV and no watching
+func initCas(t *testing.T, c *Config, b *casBackend, opts ...Option) *casBackend {
+	t.Helper()
+	if b == nil {
+		b = &casBackend{}
+	}
+	c.AddBackend("cas", func(o Options) (Backend, error) { return b, nil })
+	if err := c.Init(append([]Option{URL("cas://test"), WithDefault(&testKV), Watch(false)}, opts...)...); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,104 @@
+//noframe-config manage the keyring and the encrypted values of config
+//
+//	noframe-config keygen -keyring keys.json -id k2       add a key to keyring, it is the primary one for rotation
+//	noframe-config encrypt -keyring keys.json "mysql://..." encrypt the value or stdin by the primary key
+//	noframe-config decrypt -keyring keys.json "enc:v1:..."  decrypt the value or stdin
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ti/noframe/config"
+)
+
+const usage = `usage: noframe-config <command> [flags] [value]
+
+commands:
+  keygen   add a random key to keyring, the keyring is created if it is not exist
+  encrypt  encrypt the value or stdin by the primary key of keyring
+  decrypt  decrypt the value or stdin by keyring
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "keygen":
+		err = keygen(args)
+	case "encrypt", "decrypt":
+		err = crypt(cmd, args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "noframe-config:", err)
+		os.Exit(1)
+	}
+}
+
+func keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	path := fs.String("keyring", "keyring.json", "the path of keyring file")
+	id := fs.String("id", "", "the id of the new key")
+	primary := fs.Bool("primary", true, "encrypt the values by the new key")
+	fs.Parse(args)
+	if *id == "" {
+		return fmt.Errorf("the id of key is required")
+	}
+	keyring, err := config.LoadKeyring(*path)
+	if os.IsNotExist(err) {
+		keyring, err = config.NewKeyring(), nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, existing := range keyring.IDs() {
+		if existing == *id {
+			return fmt.Errorf("key %s is already in keyring %s", *id, *path)
+		}
+	}
+	if err := keyring.GenerateKey(*id, *primary); err != nil {
+		return err
+	}
+	if err := keyring.Save(*path); err != nil {
+		return err
+	}
+	fmt.Printf("key %s is added to %s, the primary key is %s\n", *id, *path, keyring.Primary())
+	return nil
+}
+
+func crypt(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	path := fs.String("keyring", "keyring.json", "the path of keyring file")
+	fs.Parse(args)
+	keyring, err := config.LoadKeyring(*path)
+	if err != nil {
+		return err
+	}
+	value := fs.Arg(0)
+	if fs.NArg() == 0 {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(string(b), "\r\n")
+	}
+	if cmd == "encrypt" {
+		value, err = keyring.Encrypt(value)
+	} else {
+		value, err = keyring.Decrypt(value)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
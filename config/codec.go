@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+//Codec encode and decode the config of file backend, register it by RegisterCodec,
+//exp: RegisterCodec(".hcl", hclCodec) for the formats which are not built in
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(in []byte, out interface{}) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		".json": jsonCodec{},
+		".yaml": yamlCodec{},
+		".yml":  yamlCodec{},
+		".toml": tomlCodec{},
+		".env":  dotenvCodec{},
+	}
+)
+
+//RegisterCodec register the codec of the file extension or the format in ?format= of url, exp: .toml, toml
+func RegisterCodec(ext string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[normalizeExt(ext)] = codec
+}
+
+//getCodec get the codec of the format, the files of unknown extensions are yaml
+func getCodec(format string, force bool) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	if codec, ok := codecs[normalizeExt(format)]; ok {
+		return codec, nil
+	}
+	if force {
+		return nil, fmt.Errorf("format %s is not registered", format)
+	}
+	return yamlCodec{}, nil
+}
+
+//hasCodec the codec of the file extension is registered
+func hasCodec(ext string) bool {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	_, ok := codecs[normalizeExt(ext)]
+	return ok
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+//documentCodec the codec which gets the document of the config it decodes, exp: dotenv
+type documentCodec interface {
+	UnmarshalDocument(in []byte, out interface{}) (interface{}, error)
+}
+
+//unmarshalDocument decode in to out, and get the document of in by the codec,
+//the document is nil if in can not be decoded to a map by the codec
+func unmarshalDocument(codec Codec, in []byte, out interface{}) (interface{}, error) {
+	if c, ok := codec.(documentCodec); ok {
+		return c.UnmarshalDocument(in, out)
+	}
+	if err := codec.Unmarshal(in, out); err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := codec.Unmarshal(in, &doc); err != nil {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(v, "", "\t")
+}
+
+func (jsonCodec) Unmarshal(in []byte, out interface{}) error {
+	return json.Unmarshal(in, out)
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func (yamlCodec) Unmarshal(in []byte, out interface{}) error {
+	return yaml.Unmarshal(in, out)
+}
+
+type tomlCodec struct{}
+
+func (tomlCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (tomlCodec) Unmarshal(in []byte, out interface{}) error {
+	return toml.Unmarshal(in, out)
+}
+
+//dotenvCodec the KEY=VALUE lines of .env file, the keys are matched to the fields like WithEnvOverrides,
+//exp: SERVICES_0_HOOKS_URL=http://hooks is Services[0].Hooks.Url
+type dotenvCodec struct{}
+
+func (dotenvCodec) Marshal(v interface{}) ([]byte, error) {
+	var lines []string
+	flattenEnv(reflect.ValueOf(v), nil, &lines)
+	sort.Strings(lines)
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (c dotenvCodec) Unmarshal(in []byte, out interface{}) error {
+	_, err := c.UnmarshalDocument(in, out)
+	return err
+}
+
+func (dotenvCodec) UnmarshalDocument(in []byte, out interface{}) (interface{}, error) {
+	var environ []string
+	scanner := bufio.NewScanner(bytes.NewReader(in))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		index := strings.Index(line, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("line %d of env file is not KEY=VALUE", n)
+		}
+		key, value := strings.TrimSpace(line[:index]), strings.TrimSpace(line[index+1:])
+		if len(value) >= 2 && value[0] == '"' {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of env file error for %s", n, err)
+			}
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		environ = append(environ, key+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return setEnvs(out, "", environ)
+}
+
+//flattenEnv append the KEY=VALUE lines of the scalar values in v
+func flattenEnv(v reflect.Value, tokens []string, lines *[]string) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			flattenEnv(v.Elem(), tokens, lines)
+		}
+		return
+	case reflect.Struct:
+		if hasExportedField(v.Type()) {
+			t := v.Type()
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if f.PkgPath != "" {
+					continue
+				}
+				if f.Anonymous {
+					flattenEnv(v.Field(i), tokens, lines)
+					continue
+				}
+				flattenEnv(v.Field(i), append(tokens[:len(tokens):len(tokens)], envTokens(f.Name)...), lines)
+			}
+			return
+		}
+	case reflect.Slice, reflect.Array:
+		if !isScalar(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				flattenEnv(v.Index(i), append(tokens[:len(tokens):len(tokens)], strconv.Itoa(i)), lines)
+			}
+			return
+		}
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(reflect.Indirect(v.Index(i)).Interface())
+		}
+		appendEnv(lines, tokens, strings.Join(parts, ","))
+		return
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			for _, k := range v.MapKeys() {
+				flattenEnv(v.MapIndex(k), append(tokens[:len(tokens):len(tokens)], envTokens(k.String())...), lines)
+			}
+			return
+		}
+	}
+	if isScalar(v.Type()) {
+		appendEnv(lines, tokens, fmt.Sprint(v.Interface()))
+	}
+}
+
+func appendEnv(lines *[]string, tokens []string, value string) {
+	if len(tokens) == 0 {
+		return
+	}
+	if strings.ContainsAny(value, " \t\r\n#\"'\\") {
+		value = strconv.Quote(value)
+	}
+	*lines = append(*lines, strings.Join(tokens, "_")+"="+value)
+}
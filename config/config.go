@@ -1,156 +1,452 @@
 package config
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	log "github.com/sirupsen/logrus"
 )
 
 //Config the struct of config instance
 type Config struct {
-	triggers map[string]OnChange
-	backEnds map[string]Backend
+	//listeners the listeners of fields in the order of registration
+	listeners []*listener
+	backEnds  map[string]BackendFactory
 	//some backend of config, you can use file, etcd2, etcd3, consul ...
 	instance interface{}
-	//pre instance of config
-	preInstance interface{}
-	onChange    OnChange
-	mu          sync.Mutex
+	//current the *snapshot of the config, it is swapped on every reload
+	current  atomic.Value
+	onChange OnChange
+	onError  OnError
+	//logger the logger of the last Init
+	logger Logger
+	//resolvers resolve the secret references of config
+	resolvers []SecretResolver
+	//keyring encrypt the fields tagged encrypted in Update
+	keyring *Keyring
+	//onChanges receive the whole changes of every reload
+	onChanges OnChanges
+	//validator validate the config before it is published
+	validator func(candidate interface{}) error
+	//flags the flags bind by BindFlags, they override the config loaded by backends
+	flags []*flagValue
+	//layers the layers of the last Init, they are closed by Close
+	layers []*layer
+	cancel context.CancelFunc
+	//closed the channel which is closed by Close, it stops the channels of Watch
+	closed chan struct{}
+	//wg wait for the polling goroutines
+	wg sync.WaitGroup
+	//history the configs which are applied, version is the version of the last one
+	history     []*HistoryEntry
+	historySize int
+	version     int
+	//queue the events to the dispatcher when the listeners are async, overflow keeps the events in order when it is full,
+	//queueStopped is true when the dispatcher is stopped
+	queue        chan *event
+	overflow     []*event
+	queueStopped bool
+	//pending the events to be delivered when the listeners are sync, dispatching is true when they are being delivered
+	pending     []*event
+	dispatching bool
+	//updateMu serialize the updates of config
+	updateMu sync.Mutex
+	mu       sync.Mutex
+}
+
+//snapshot the published config, it must not be changed after it is published
+type snapshot struct {
+	cfg interface{}
+	//revision the revision of backend which loads the config, it is 0 if the backend has no revision
+	revision int64
+	//source the scheme of backend which loads the config
+	source string
+	//written the config of the layer which is written by Update, it is written back by Rollback
+	written interface{}
+	//secrets the paths of values which are resolved from secret references
+	secrets map[string]bool
 }
 
 //Init init config by url
 func (c *Config) Init(opts ...Option) error {
+	return c.InitContext(context.Background(), opts...)
+}
+
+//InitContext init config by url, the polling and watching of backends are stopped when ctx is done
+//or Close is called, the config of the previous Init is closed first
+func (c *Config) InitContext(ctx context.Context, opts ...Option) error {
 	var options Options
 	for _, o := range opts {
 		o(&options)
 	}
+	if options.Logger == nil {
+		options.Logger = defaultLogger
+	}
+	c.mu.Lock()
+	c.logger = options.Logger
+	c.mu.Unlock()
+	if err := c.Close(); err != nil {
+		c.log().Warn("close previous config error", Fields{"error": err})
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	options.Context = ctx
+	c.mu.Lock()
+	c.cancel = cancel
+	if options.AsyncListeners > 0 {
+		c.queue, c.overflow, c.queueStopped = make(chan *event, options.AsyncListeners), nil, false
+		c.wg.Add(1)
+		go c.runDispatcher(ctx, c.queue)
+	}
+	c.mu.Unlock()
 	if options.DefaultConfig == nil {
 		options.DefaultConfig = c.instance
 	} else {
 		c.instance = options.DefaultConfig
 	}
-	if options.URL == "" {
+	c.mu.Lock()
+	c.validator = options.Validator
+	c.historySize = options.HistorySize
+	c.resolvers = append([]SecretResolver{}, options.SecretResolvers...)
+	c.keyring = options.Keyring
+	if options.Keyring != nil {
+		c.resolvers = append([]SecretResolver{options.Keyring}, c.resolvers...)
+	}
+	c.mu.Unlock()
+	sources := append([]string{}, options.Sources...)
+	if len(sources) == 0 && options.URL != "" {
+		sources = []string{options.URL}
+	}
+	sources = append(sources, options.overrides...)
+	if len(sources) == 0 {
 		if c.instance != nil {
-			c.onReloaded(c.instance)
-			return nil
+			c.mu.Lock()
+			cfg, err := applyFlags(cloneInstance(c.instance), c.flags)
+			c.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			s, err := c.newSnapshot(cfg)
+			if err != nil {
+				return err
+			}
+			return c.onReloaded(s)
 		}
 		return errors.New("config not set")
 	}
-	var backend Backend
-	if options.scheme == "" {
-		options.scheme = fileScheme
+	layers := make([]*layer, len(sources))
+	for i, source := range sources {
+		layerOptions := options
+		layerOptions.Sources = nil
+		if err := setURL(&layerOptions, source); err != nil {
+			return err
+		}
+		if layerOptions.scheme == "" {
+			layerOptions.scheme = fileScheme
+		}
+		factory, ok := c.getBackend(layerOptions.scheme)
+		if !ok {
+			return fmt.Errorf("[%s] is not a valid backend url", source)
+		}
+		//every layer has its own backend, so the layers never share the state of backend
+		backend, err := factory(layerOptions)
+		if err != nil {
+			return err
+		}
+		l := &layer{options: layerOptions, backend: backend}
+		l.options.OnLoaded = func(cfg interface{}) error {
+			return c.onLayerLoaded(layers, l, cfg, nil)
+		}
+		l.options.onDocument = func(cfg, doc interface{}) error {
+			return c.onLayerLoaded(layers, l, cfg, doc)
+		}
+		l.options.OnError = func(err error) {
+			c.layerError(l, err)
+		}
+		l.options.OnWatching = func(watching bool) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			l.watching = watching
+		}
+		layers[i] = l
 	}
-	var ok bool
-	backend, ok = c.backEnds[options.scheme]
-	if !ok {
-		return fmt.Errorf("[%s] is not a valid backend url", options.URL)
+	c.mu.Lock()
+	c.layers = layers
+	c.mu.Unlock()
+	//the merged config is published when the last layer is loaded
+	for _, l := range layers {
+		if err := l.backend.LoadConfig(l.options); err != nil {
+			c.layerError(l, err)
+			return err
+		}
 	}
-	options.OnLoaded = c.onReloaded
-	if err := backend.LoadConfig(options); err != nil {
-		return err
+	for _, l := range layers {
+		if l.options.ReloadDelay > time.Second {
+			c.wg.Add(1)
+			go c.poll(ctx, l)
+		}
+	}
+	return nil
+}
+
+//poll reload the config of layer after every ReloadDelay until ctx is done
+func (c *Config) poll(ctx context.Context, l *layer) {
+	defer c.wg.Done()
+	for {
+		// Delay after each request
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(l.options.ReloadDelay):
+		}
+		// Attempt to reload the config
+		err := l.backend.LoadConfig(l.options)
+		if err != nil {
+			c.log().Error("reload config error", Fields{"scheme": l.options.scheme, "error": err})
+			c.layerError(l, err)
+			continue
+		}
+	}
+}
+
+//Close stop the polling and watching of backends, and close the backends which implement io.Closer,
+//the config loaded is still available after Close
+func (c *Config) Close() error {
+	c.mu.Lock()
+	cancel, layers, closed := c.cancel, c.layers, c.closed
+	if cancel != nil {
+		c.cancel, c.layers, c.queue, c.closed = nil, nil, nil, nil
+	}
+	c.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	if closed != nil {
+		close(closed)
 	}
-	if options.ReloadDelay > time.Second {
-		go func() {
-			for {
-				// Delay after each request
-				<-time.After(options.ReloadDelay)
-				// Attempt to reload the config
-				err := backend.LoadConfig(options)
-				if err != nil {
-					log.Error(err)
-					continue
-				}
+	cancel()
+	c.wg.Wait()
+	var closeErr error
+	for _, l := range layers {
+		if closer, ok := l.backend.(io.Closer); ok {
+			if err := closer.Close(); err != nil && closeErr == nil {
+				closeErr = err
 			}
-		}()
+		}
 	}
+	return closeErr
+}
 
+//onLayerLoaded merge the config loaded by one of the layers with others,
+//nothing is published until every layer is loaded by Init
+func (c *Config) onLayerLoaded(layers []*layer, l *layer, cfg, doc interface{}) error {
+	c.mu.Lock()
+	l.cfg, l.doc = cfg, doc
+	l.loadedAt = time.Now()
+	if r, ok := l.backend.(Revisioner); ok {
+		l.revision = r.Revision()
+	}
+	for _, v := range layers {
+		if v == nil || v.cfg == nil {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	merged, written, err := c.mergeWithFlags(l.options.DefaultConfig, layers)
+	c.mu.Unlock()
+	var s *snapshot
+	if err == nil {
+		s, err = c.newSnapshot(merged)
+	}
+	if err != nil {
+		c.layerError(l, err)
+		return err
+	}
+	s.written = written
+	s.revision, s.source = l.revision, l.options.scheme
+	if err := c.onReloaded(s); err != nil {
+		c.layerError(l, err)
+		return err
+	}
 	return nil
 }
 
+//mergeWithFlags merge the layers and apply the flags, written is the config of the writable layer,
+//it should be called with lock
+func (c *Config) mergeWithFlags(defaultConfig interface{}, layers []*layer) (merged, written interface{}, err error) {
+	merged, _, err = mergeLayers(defaultConfig, layers)
+	if err == nil {
+		merged, err = applyFlags(merged, c.flags)
+	}
+	if w := writable(layers); w != nil {
+		written = w.cfg
+	}
+	return merged, written, err
+}
+
+//newSnapshot new a snapshot of config whose secret references are resolved
+func (c *Config) newSnapshot(cfg interface{}) (*snapshot, error) {
+	c.mu.Lock()
+	resolvers := c.resolvers
+	c.mu.Unlock()
+	resolved, secrets, err := resolveSecrets(cfg, resolvers)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{cfg: resolved, secrets: secrets}, nil
+}
+
 //New new config use default config for config
 func New(defaultConfig interface{}) *Config {
 	return &Config{
 		instance: defaultConfig,
-		triggers: make(map[string]OnChange),
-		backEnds: map[string]Backend{fileScheme: &fileBackend{}},
+		backEnds: make(map[string]BackendFactory),
 	}
 }
 
-//GetConfig set default config for a instance
+//GetConfig get the current config, it is shared by all readers so it must be read only,
+//use Snapshot to get a copy which can be changed
 func (c *Config) GetConfig() interface{} {
+	if s, ok := c.current.Load().(*snapshot); ok {
+		return s.cfg
+	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return c.instance
 }
 
-//AddBackend add backend
-func (c *Config) AddBackend(scheme string, backend Backend) {
+//Snapshot get a copy of the current config, it is consistent for the duration of a request
+func (c *Config) Snapshot() interface{} {
+	return cloneInstance(c.GetConfig())
+}
+
+//Revision get the revision of the current config in backend, exp: ModRevision of etcd, ModifyIndex of consul,
+//it is 0 if the backend has no revision
+func (c *Config) Revision() int64 {
+	if s, ok := c.current.Load().(*snapshot); ok {
+		return s.revision
+	}
+	return 0
+}
+
+//AddBackend add backend factory for this instance only, it overrides the backend added by AddBackend
+func (c *Config) AddBackend(scheme string, factory BackendFactory) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.backEnds[scheme] = backend
+	c.backEnds[scheme] = factory
 }
 
-//SetFieldListener bind some trigger when config is changed
-func (c *Config) SetFieldListener(field string, onChange OnChange) {
+//log the logger of config, it is the default logger before Init
+func (c *Config) log() Logger {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if onChange == nil {
-		//remove listener
-		delete(c.triggers, field)
-		return
+	if c.logger == nil {
+		return defaultLogger
 	}
-	c.triggers[field] = onChange
+	return c.logger
 }
 
-//onReloaded notify some trigger on data
-func (c *Config) onReloaded(cfg interface{}) {
+//getBackend get the backend factory of instance, or the one added by AddBackend
+func (c *Config) getBackend(scheme string) (BackendFactory, bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	hasPreInstance := c.preInstance != nil
-	newConfig := reflect.Indirect(reflect.ValueOf(cfg)).Interface()
-	if hasPreInstance && reflect.DeepEqual(c.preInstance, newConfig) {
-		return
+	factory, ok := c.backEnds[scheme]
+	c.mu.Unlock()
+	if ok {
+		return factory, true
 	}
-	for field, onChange := range c.triggers {
-		var oldValue interface{}
-		if hasPreInstance {
-			oldValue, _ = getFieldValue(c.preInstance, field)
-		}
-		newValue, err := getFieldValue(newConfig, field)
-		if err != nil {
-			log.Warnf("can not get config by field %s", err)
-			continue
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	factory, ok = backends[scheme]
+	return factory, ok
+}
+
+//SetChangeListener bind a trigger which receives all the changes once per reload,
+//the first config is a single added change of the root path ""
+func (c *Config) SetChangeListener(onChanges OnChanges) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onChanges = onChanges
+}
+
+//SetErrorListener bind a trigger when a reloaded config is rejected
+func (c *Config) SetErrorListener(onError OnError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onError = onError
+}
+
+//validate validate the candidate config by the validator option and the Validate method of the config
+func (c *Config) validate(cfg interface{}) error {
+	c.mu.Lock()
+	validate := c.validator
+	c.mu.Unlock()
+	if validate != nil {
+		if err := validate(cfg); err != nil {
+			return err
 		}
-		if newValue == nil {
-			if oldValue != nil {
-				tx := reflect.Indirect(reflect.ValueOf(oldValue)).Type()
-				newValue = reflect.New(tx).Interface()
-				if reflect.ValueOf(oldValue).Kind() != reflect.Ptr {
-					newValue = reflect.Indirect(reflect.ValueOf(newValue)).Interface()
-				}
-				onChange(oldValue, newValue)
-			}
-		} else {
-			if !hasPreInstance || !reflect.DeepEqual(oldValue, newValue) {
-				if oldValue == nil {
-					tx := reflect.Indirect(reflect.ValueOf(newValue)).Type()
-					oldValue = reflect.New(tx).Interface()
-					if reflect.ValueOf(newValue).Kind() != reflect.Ptr {
-						oldValue = reflect.Indirect(reflect.ValueOf(oldValue)).Interface()
-					}
-				}
-				onChange(oldValue, newValue)
-			}
+	}
+	if v, ok := cfg.(validator); ok {
+		return v.Validate()
+	}
+	if v, ok := reflect.Indirect(reflect.ValueOf(cfg)).Interface().(validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+type validator interface {
+	Validate() error
+}
+
+//onReloaded publish the fresh config which is loaded by backend and notify some trigger on data,
+//the config is rejected and the previous config is kept if it is invalid
+func (c *Config) onReloaded(s *snapshot) error {
+	cfg := s.cfg
+	if err := c.validate(cfg); err != nil {
+		err = fmt.Errorf("config is rejected by validator: %w", err)
+		c.reportError(err)
+		return err
+	}
+	c.mu.Lock()
+	var preInstance interface{}
+	pre, _ := c.current.Load().(*snapshot)
+	if pre != nil {
+		preInstance = reflect.Indirect(reflect.ValueOf(pre.cfg)).Interface()
+	}
+	hasPreInstance := preInstance != nil
+	newConfig := reflect.Indirect(reflect.ValueOf(cfg)).Interface()
+	if hasPreInstance && reflect.DeepEqual(preInstance, newConfig) {
+		if pre.revision != s.revision {
+			//the config is not changed, only the revision is moved
+			c.current.Store(&snapshot{cfg: pre.cfg, written: pre.written, secrets: pre.secrets, revision: s.revision, source: s.source})
 		}
+		c.mu.Unlock()
+		return nil
 	}
-	c.preInstance = clone(cfg)
+	c.current.Store(s)
+	var preCfg interface{}
+	if pre != nil {
+		preCfg = pre.cfg
+	}
+	changes := Diff(preCfg, cfg)
+	c.record(s, maskChanges(changes, pre, s))
+	ev := &event{
+		pre:         preCfg,
+		cfg:         cfg,
+		preInstance: preInstance,
+		newConfig:   newConfig,
+		changes:     changes,
+		listeners:   append([]*listener{}, c.listeners...),
+		onChanges:   c.onChanges,
+	}
+	c.mu.Unlock()
+	//the listeners are called outside of the lock, so they can read the config
+	c.dispatch(ev)
+	return nil
 }
 
 func getFieldValue(src interface{}, path string) (interface{}, error) {
@@ -161,6 +457,10 @@ func getFieldValue(src interface{}, path string) (interface{}, error) {
 		}
 		return nil, fmt.Errorf("path %s errr for %s", path, err)
 	}
+	if !dist.IsValid() {
+		//the key of map is not exist
+		return nil, nil
+	}
 	return dist.Interface(), nil
 }
 
@@ -226,20 +526,64 @@ func compile(src string) []string {
 	return a
 }
 
-// copy fully copy config instance, include map
-func clone(src interface{}) interface{} {
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	dec := json.NewDecoder(&buf)
-	err := enc.Encode(src)
-	if err != nil {
+// cloneInstance fully copy config instance by reflect, the fields which json skips are kept, exp: `json:"-"`,
+// the copy is a pointer if src is a pointer
+func cloneInstance(src interface{}) interface{} {
+	if src == nil {
 		return nil
 	}
-	t := reflect.Indirect(reflect.ValueOf(src)).Type()
-	dist := reflect.New(t).Interface()
-	err = dec.Decode(&dist)
-	if err != nil {
-		return nil
+	v := reflect.ValueOf(src)
+	dist := reflect.New(v.Type()).Elem()
+	copyValue(dist, v)
+	return dist.Interface()
+}
+
+//copyValue copy src to dist, the unexported fields of struct are copied shallowly
+func copyValue(dist, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		dist.Set(reflect.New(src.Type().Elem()))
+		copyValue(dist.Elem(), src.Elem())
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		elem := reflect.New(src.Elem().Type()).Elem()
+		copyValue(elem, src.Elem())
+		dist.Set(elem)
+	case reflect.Struct:
+		dist.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).PkgPath == "" {
+				copyValue(dist.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dist.Index(i), src.Index(i))
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		dist.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dist.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		dist.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		for _, k := range src.MapKeys() {
+			elem := reflect.New(src.Type().Elem()).Elem()
+			copyValue(elem, src.MapIndex(k))
+			dist.SetMapIndex(k, elem)
+		}
+	default:
+		dist.Set(src)
 	}
-	return reflect.Indirect(reflect.ValueOf(dist)).Interface()
 }
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// envScheme the sheme for environment variables
+const envScheme = "env"
+
+//envBackend load the config from the environment variables which have the prefix,
+//exp: env://APP, APP_SERVICES_0_HOOKS_URL is set to the field Services[0].Hooks.Url
+type envBackend struct {
+}
+
+func newEnvBackend(o Options) (Backend, error) {
+	return &envBackend{}, nil
+}
+
+// LoadConfig get config from environment variables
+func (e *envBackend) LoadConfig(o Options) error {
+	if o.DefaultConfig == nil {
+		//this should not be happen
+		panic("default config can not be nil")
+	}
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return err
+	}
+	cfg := o.NewConfig()
+	doc, err := setEnvs(cfg, u.Host+u.Path, os.Environ())
+	if err != nil {
+		return err
+	}
+	return o.LoadedDocument(cfg, doc)
+}
+
+//setEnvs set the environment variables which have the prefix to the fields of cfg,
+//the variables which do not match any field are ignored. It returns the document of the fields which are set
+func setEnvs(cfg interface{}, prefix string, environ []string) (map[string]interface{}, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("config %T should be a pointer", cfg)
+	}
+	doc := make(map[string]interface{})
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	prefix = strings.ToUpper(prefix)
+	sort.Strings(environ)
+	for _, kv := range environ {
+		index := strings.Index(kv, "=")
+		if index < 0 || !strings.HasPrefix(kv[:index], prefix) {
+			continue
+		}
+		key, value := kv[len(prefix):index], kv[index+1:]
+		if key == "" {
+			continue
+		}
+		if _, err := setEnv(v.Elem(), strings.Split(key, "_"), value, doc); err != nil {
+			return nil, fmt.Errorf("env %s error for %s", kv[:index], err)
+		}
+	}
+	itemsOf(reflect.TypeOf(cfg), doc)
+	return doc, nil
+}
+
+//itemsOf convert the documents of the slices in doc to itemsDocument, they are the maps keyed by the indexes
+//of items when they are set by env, so only the items which are set override the former sources
+func itemsOf(t reflect.Type, doc interface{}) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return doc
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if f.Anonymous {
+				//the fields of embedded struct are promoted
+				itemsOf(f.Type, m)
+			}
+			if v, ok := m[f.Name]; ok {
+				m[f.Name] = itemsOf(f.Type, v)
+			}
+		}
+	case reflect.Map:
+		for k, v := range m {
+			m[k] = itemsOf(t.Elem(), v)
+		}
+	case reflect.Slice:
+		items := make(itemsDocument, len(m))
+		for k, v := range m {
+			if i, err := strconv.Atoi(k); err == nil {
+				items[i] = itemsOf(t.Elem(), v)
+			}
+		}
+		return items
+	}
+	return m
+}
+
+//setEnv set the value to the field which matches the tokens of the env name, the path of field is added to doc,
+//exp: SERVICES_0_HOOKS_URL matches Services[0].Hooks.Url
+func setEnv(v reflect.Value, tokens []string, value string, doc map[string]interface{}) (bool, error) {
+	if len(tokens) == 0 {
+		return true, setString(v, value)
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			if !v.CanSet() {
+				return false, nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setEnv(v.Elem(), tokens, value, doc)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if f.Anonymous {
+				//the fields of embedded struct are promoted
+				if ok, err := setEnv(v.Field(i), tokens, value, doc); ok || err != nil {
+					return ok, err
+				}
+			}
+			for _, name := range fieldNames(f) {
+				nameTokens := envTokens(name)
+				if !hasTokens(tokens, nameTokens) {
+					continue
+				}
+				child := docChild(doc, f.Name)
+				if ok, err := setEnv(v.Field(i), tokens[len(nameTokens):], value, child); ok || err != nil {
+					setDoc(doc, f.Name, child, value, ok)
+					return ok, err
+				}
+			}
+		}
+	case reflect.Slice:
+		n, err := strconv.Atoi(tokens[0])
+		if err != nil || n < 0 {
+			return false, nil
+		}
+		if n >= v.Len() {
+			grown := reflect.MakeSlice(v.Type(), n+1, n+1)
+			reflect.Copy(grown, v)
+			v.Set(grown)
+		}
+		//the items are recorded by index, they are converted to itemsDocument by itemsOf
+		key := strconv.Itoa(n)
+		child := docChild(doc, key)
+		ok, err := setEnv(v.Index(n), tokens[1:], value, child)
+		setDoc(doc, key, child, value, ok)
+		return ok, err
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return false, nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		//the key of map is matched case insensitive, the key is lower case if it is not exist
+		keyTokens := 1
+		if isScalar(v.Type().Elem()) {
+			keyTokens = len(tokens)
+		}
+		key := strings.ToLower(strings.Join(tokens[:keyTokens], "_"))
+		for _, k := range v.MapKeys() {
+			kTokens := envTokens(k.String())
+			if isScalar(v.Type().Elem()) && len(kTokens) != len(tokens) {
+				continue
+			}
+			if hasTokens(tokens, kTokens) {
+				key, keyTokens = k.String(), len(kTokens)
+				break
+			}
+		}
+		mapKey := reflect.ValueOf(key).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if old := v.MapIndex(mapKey); old.IsValid() {
+			elem.Set(old)
+		}
+		child := docChild(doc, key)
+		ok, err := setEnv(elem, tokens[keyTokens:], value, child)
+		if ok && err == nil {
+			v.SetMapIndex(mapKey, elem)
+			setDoc(doc, key, child, value, ok)
+		}
+		return ok, err
+	}
+	return false, nil
+}
+
+//docChild the document of the child of doc, it is nil if the document is not recorded
+func docChild(doc map[string]interface{}, key string) map[string]interface{} {
+	if doc == nil {
+		return nil
+	}
+	if child, ok := doc[key].(map[string]interface{}); ok {
+		return child
+	}
+	return make(map[string]interface{})
+}
+
+//setDoc add the child to doc if the field of key is set, the value is added if the field has no children
+func setDoc(doc map[string]interface{}, key string, child map[string]interface{}, value string, ok bool) {
+	if doc == nil || !ok {
+		return
+	}
+	if len(child) > 0 {
+		doc[key] = child
+	} else {
+		doc[key] = value
+	}
+}
+
+//envTokens split the name to the upper case tokens of env name,
+//exp: test_config is TEST CONFIG, DataSource is DATASOURCE
+func envTokens(name string) []string {
+	return strings.FieldsFunc(strings.ToUpper(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func hasTokens(tokens, prefix []string) bool {
+	if len(prefix) == 0 || len(prefix) > len(tokens) {
+		return false
+	}
+	for i, t := range prefix {
+		if !strings.EqualFold(tokens[i], t) {
+			return false
+		}
+	}
+	return true
+}
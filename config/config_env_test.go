@@ -0,0 +1,87 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type envKV struct {
+	TestKV
+	Timeout time.Duration `json:"timeout"`
+	Ports   []int
+}
+
+func TestSetEnvs(t *testing.T) {
+	cfg := &envKV{TestKV: *cloneInstance(&testKV).(*TestKV)}
+	cfg.DataSource = map[string]string{"cache": "redis://127.0.0.1:6379"}
+	environ := []string{
+		"APP_ADDR=:8080",
+		"APP_SERVICES_0_HOOKS_URL=http://127.0.0.1:9091/timeup",
+		"APP_SERVICES_2_NAME=serviceC",
+		"APP_DATASOURCE_CACHE=redis://127.0.0.1:6380",
+		"APP_DATASOURCE_READ_ONLY=mysql://read",
+		"APP_TEST_CONFIG_KEY=value",
+		"APP_TIMEOUT=3s",
+		"APP_PORTS=80,443",
+		"APP_UNKNOWN=ignored",
+		"OTHER_ADDR=:7070",
+	}
+	if _, err := setEnvs(cfg, "APP", environ); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":8080" {
+		t.Fatalf("addr %s does not match expect", cfg.Addr)
+	}
+	if cfg.Services[0].Hooks.Url != "http://127.0.0.1:9091/timeup" || cfg.Services[0].Name != "serviceA" {
+		t.Fatalf("service %v does not match expect", cfg.Services[0])
+	}
+	if len(cfg.Services) != 3 || cfg.Services[2].Name != "serviceC" {
+		t.Fatalf("services %v does not match expect", cfg.Services)
+	}
+	expect := map[string]string{"cache": "redis://127.0.0.1:6380", "read_only": "mysql://read"}
+	if !reflect.DeepEqual(cfg.DataSource, expect) {
+		t.Fatalf("data source %v does not match expect %v", cfg.DataSource, expect)
+	}
+	if cfg.TestConfig["key"] != "value" {
+		t.Fatalf("test config %v does not match expect", cfg.TestConfig)
+	}
+	if cfg.Timeout != 3*time.Second || !reflect.DeepEqual(cfg.Ports, []int{80, 443}) {
+		t.Fatalf("timeout %s ports %v does not match expect", cfg.Timeout, cfg.Ports)
+	}
+	if _, err := setEnvs(cfg, "APP", []string{"APP_TIMEOUT=3"}); err == nil {
+		t.Fatal("expect error for invalid duration")
+	}
+}
+
+func TestEnvOverridesItems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	raw := `{"Addr":":8080","Services":[{"Name":"fromfile","Hooks":{"Url":"http://file"}},{"Name":"second"}]}`
+	if err := ioutil.WriteFile(path, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NOFRAME_TEST_SERVICES_0_HOOKS_URL", "http://env")
+	c := New(&TestKV{})
+	if err := c.Init(URL("file://"+path), Watch(false), WithDefault(cloneInstance(&testKV)), WithEnvOverrides("NOFRAME_TEST")); err != nil {
+		t.Fatal(err)
+	}
+	//only the field of the item which is set by env overrides the services of file
+	services := c.GetConfig().(*TestKV).Services
+	if len(services) != 2 || services[0].Name != "fromfile" || services[0].Hooks.Url != "http://env" || services[1].Name != "second" {
+		t.Fatalf("services %+v does not match expect", services)
+	}
+}
+
+func TestWithEnvOverrides(t *testing.T) {
+	t.Setenv("NOFRAME_TEST_LOGLEVEL", "warn")
+	c := New(&TestKV{})
+	if err := c.Init(WithDefault(&testKV), WithEnvOverrides("NOFRAME_TEST")); err != nil {
+		t.Fatal(err)
+	}
+	current := c.GetConfig().(*TestKV)
+	if current.LogLevel != "warn" || current.Addr != testKV.Addr {
+		t.Fatalf("config %v does not match expect", current)
+	}
+}
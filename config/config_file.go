@@ -2,42 +2,79 @@ package config
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
-	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 // FileScheme the sheme for file
 const fileScheme = "file"
 
+//fileDebounce the time to wait for the end of a burst of events, exp: an editor writes a temp file and renames it
+const fileDebounce = 100 * time.Millisecond
+
+//includeKeys the keys of the files which are included by a file, exp: include: [base.yaml, conf.d/]
+var includeKeys = []string{"include", "$include"}
+
+//fileBackend load the config from file, the format is chosen by the extension of file or ?format= of url,
+//exp: file:///etc/app/config?format=toml. The url can be a directory, exp: file://conf.d/, the files in it are
+//merged in lexical order, and a file can include other files or directories by include or $include
 type fileBackend struct {
-	path     string
-	instance interface{}
-	onLoaded OnLoaded
-	loaded   bool
-}
+	path string
+	//codec the codec forced by ?format=, the codec of file is chosen by its extension if it is nil
+	codec   Codec
+	options Options
+	loaded  bool
 
-func init() {
-	AddBackend(fileScheme, &fileBackend{})
+	mu sync.Mutex
+	//files and dirs the files and directories which the config is loaded from, they are watched
+	files []string
+	dirs  []string
 }
 
-func (f *fileBackend) reloadFile() error {
-	bytes, err := ioutil.ReadFile(f.path)
+func newFileBackend(o Options) (Backend, error) {
+	u, err := url.Parse(o.URL)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	f := &fileBackend{path: u.Host + u.Path}
+	if format := u.Query().Get("format"); format != "" {
+		if f.codec, err = getCodec(format, true); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+//codecOf the codec of file, forced is the codec of ?format= which applies to the root file or directory
+func codecOf(path string, forced Codec) Codec {
+	if forced != nil {
+		return forced
+	}
+	codec, _ := getCodec(filepath.Ext(path), false)
+	return codec
+}
+
+//reloadFile load the config and the document of the files
+func (f *fileBackend) reloadFile() (interface{}, interface{}, error) {
+	loader := &fileLoader{options: f.options, visiting: make(map[string]bool)}
+	if err := loader.load(f.path, f.codec); err != nil {
+		return nil, nil, err
 	}
-	ext := filepath.Ext(f.path)
-	err = unmarshal(bytes, f.instance, ext)
+	cfg, doc, err := mergeLayers(f.options.DefaultConfig, loader.layers)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	return nil
+	f.mu.Lock()
+	f.files, f.dirs = loader.files, loader.dirs
+	f.mu.Unlock()
+	return cfg, doc, nil
 }
 
 // LoadConfig get config from file
@@ -46,19 +83,17 @@ func (f *fileBackend) LoadConfig(o Options) error {
 		//this should not be happen
 		panic("default config can not be nil")
 	}
-	u, err := url.Parse(o.URL)
-	if err != nil {
-		return err
-	}
-	if f.path == "" {
-		f.path = u.Host + u.Path
-	}
-	f.instance = o.DefaultConfig
-	f.onLoaded = o.OnLoaded
+	f.options = o
 
-	cfg := o.DefaultConfig
-	ext := filepath.Ext(f.path)
-	bytes, err := ioutil.ReadFile(f.path)
+	cfg := o.NewConfig()
+	_, err := os.Stat(f.path)
+	if err != nil && os.IsNotExist(err) && strings.HasSuffix(f.path, "/") {
+		//the empty directory is loaded and watched, so the files created in it later are loaded
+		if mkdirError := os.MkdirAll(f.path, os.FileMode(0700)); mkdirError != nil {
+			return fmt.Errorf("try to open dir %s, try to mkdir error %s", f.path, mkdirError)
+		}
+		err = nil
+	}
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return err
@@ -72,76 +107,278 @@ func (f *fileBackend) LoadConfig(o Options) error {
 				return fmt.Errorf("try to open file %s, try to mkdir %s,  error %s", err, f.path, mkdirError)
 			}
 		}
-		if writeErr := ioutil.WriteFile(f.path, marshal(cfg, ext), os.FileMode(0700)); writeErr != nil {
+		data, marshalErr := codecOf(f.path, f.codec).Marshal(cfg)
+		if marshalErr != nil {
+			return fmt.Errorf("try to open file %s, try to encode default config error %s", err, marshalErr)
+		}
+		if writeErr := ioutil.WriteFile(f.path, data, os.FileMode(0700)); writeErr != nil {
 			return fmt.Errorf("try to open file %s, try to write default config config file rror %s", err, writeErr)
 		}
-		o.OnLoaded(cfg)
-		return nil
+		return o.OnLoaded(cfg)
 	}
-	err = unmarshal(bytes, cfg, ext)
+	cfg, doc, err := f.reloadFile()
 	if err != nil {
 		return err
 	}
 	prefixKeys := GetPrefixKeys(f.path, o.DefaultConfig)
 	if o.Watch && !f.loaded {
-		go f.watch(context.Background(), f.path, prefixKeys)
+		if err := f.watch(o.Context, f.path, prefixKeys); err != nil {
+			return err
+		}
 	}
 	f.loaded = true
-	o.OnLoaded(cfg)
+	return o.LoadedDocument(cfg, doc)
+}
+
+// SaveConfig write config to file
+func (f *fileBackend) SaveConfig(o Options, cfg interface{}) error {
+	f.mu.Lock()
+	merged := len(f.dirs) > 0 || len(f.files) > 1
+	f.mu.Unlock()
+	if merged {
+		return fmt.Errorf("config %s is merged from files, it can not be saved", f.path)
+	}
+	data, err := codecOf(f.path, f.codec).Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("encode config file %s error %s", f.path, err)
+	}
+	if err := ioutil.WriteFile(f.path, data, os.FileMode(0700)); err != nil {
+		return fmt.Errorf("write config file %s error %s", f.path, err)
+	}
 	return nil
 }
 
-func (f *fileBackend) watch(ctx context.Context, rootKey string, keys []string) {
+//watchPaths the directories which are watched, they are the parents of the loaded files and the loaded directories,
+//the parents are watched instead of the files, so the files replaced by rename or symlink are still watched
+func (f *fileBackend) watchPaths() map[string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	paths := make(map[string]bool, len(f.files)+len(f.dirs))
+	for _, file := range f.files {
+		paths[filepath.Dir(filepath.Clean(file))] = true
+	}
+	for _, dir := range f.dirs {
+		paths[filepath.Clean(dir)] = true
+	}
+	return paths
+}
+
+//targets the files which the loaded files are resolved to by symlinks
+func (f *fileBackend) targets() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	targets := make(map[string]string, len(f.files))
+	for _, file := range f.files {
+		targets[filepath.Clean(file)], _ = filepath.EvalSymlinks(file)
+	}
+	return targets
+}
+
+//isChanged the file of event changes the config, it is a loaded file, a file in a loaded directory,
+//or a symlink which a loaded file is resolved by, exp: ..data of the ConfigMap of Kubernetes
+func (f *fileBackend) isChanged(path string, targets map[string]string) bool {
+	path = filepath.Clean(path)
+	if _, ok := targets[path]; ok {
+		return true
+	}
+	f.mu.Lock()
+	dirs := f.dirs
+	f.mu.Unlock()
+	name := filepath.Base(path)
+	for _, dir := range dirs {
+		if filepath.Dir(path) == filepath.Clean(dir) && !strings.HasPrefix(name, ".") &&
+			(f.codec != nil || hasCodec(filepath.Ext(name))) {
+			return true
+		}
+	}
+	for file, target := range targets {
+		if resolved, _ := filepath.EvalSymlinks(file); resolved != target {
+			return true
+		}
+	}
+	return false
+}
+
+//watch add the watcher of file before it returns, so the changes after loading are not missed
+func (f *fileBackend) watch(ctx context.Context, rootKey string, keys []string) error {
 	watcher, err := fsnotify.NewWatcher()
-	l := log.WithField("action", "watch_file").WithField("root", rootKey)
 	if err != nil {
-		l.Error("watch file %s error ", rootKey, err)
+		return fmt.Errorf("watch file %s error %s", rootKey, err)
+	}
+	watched := make(map[string]bool)
+	if err = f.syncWatches(watcher, watched); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watch file %s error %s", rootKey, err)
+	}
+	f.options.ReportWatching(true)
+	go f.onWatch(ctx, watcher, rootKey, watched, f.targets())
+	return nil
+}
+
+//syncWatches watch the directories of the loaded files, and remove the ones which are not included any more
+func (f *fileBackend) syncWatches(watcher *fsnotify.Watcher, watched map[string]bool) error {
+	paths := f.watchPaths()
+	for path := range watched {
+		if !paths[path] {
+			watcher.Remove(path)
+			delete(watched, path)
+		}
 	}
+	for path := range paths {
+		if watched[path] {
+			continue
+		}
+		if err := watcher.Add(path); err != nil {
+			return err
+		}
+		watched[path] = true
+	}
+	return nil
+}
+
+//onWatch reload the config after the burst of events is over, the watching is kept after the errors of reloading,
+//the last config which is loaded is used until the files are fixed
+func (f *fileBackend) onWatch(ctx context.Context, watcher *fsnotify.Watcher, rootKey string, watched map[string]bool, targets map[string]string) {
+	fields := Fields{"action": "watch_file", "scheme": fileScheme, "key": rootKey}
+	defer f.options.ReportWatching(false)
 	defer watcher.Close()
-	done := make(chan bool)
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Op == fsnotify.Chmod {
-					continue
-				}
-				err = f.reloadFile()
-				if err != nil {
-					l.Error(err)
-					return
-				}
-				f.onLoaded(f.instance)
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				l.Error(err)
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod || !f.isChanged(event.Name, targets) {
+				continue
 			}
+			reload = time.After(fileDebounce)
+		case <-reload:
+			reload = nil
+			cfg, doc, err := f.reloadFile()
+			if err != nil {
+				f.options.Log().Error("reload file error", withError(fields, err))
+				f.options.ReportError(err)
+				continue
+			}
+			targets = f.targets()
+			if err := f.syncWatches(watcher, watched); err != nil {
+				f.options.Log().Error("watch included file error", withError(fields, err))
+				f.options.ReportError(err)
+			}
+			f.options.LoadedDocument(cfg, doc)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			f.options.Log().Error("watch file error", withError(fields, err))
+			f.options.ReportError(err)
 		}
-	}()
-	err = watcher.Add(rootKey)
+	}
+}
+
+//fileLoader load the configs of a file and the files included by it in the order of merging
+type fileLoader struct {
+	options Options
+	layers  []*layer
+	files   []string
+	dirs    []string
+	//visiting the files which are being loaded, it is used to find the cycle of includes
+	visiting map[string]bool
+}
+
+//load load the file or the files in directory, the included files are loaded before the file which includes them
+func (l *fileLoader) load(path string, forced Codec) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return l.loadDir(path, forced)
+	}
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if l.visiting[abs] {
+		return fmt.Errorf("file %s is included by itself", path)
+	}
+	l.visiting[abs] = true
+	defer delete(l.visiting, abs)
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	l.files = append(l.files, path)
+	cfg := l.options.NewConfig()
+	doc, err := unmarshalDocument(codecOf(path, forced), bytes, cfg)
+	if err != nil {
+		return fmt.Errorf("decode file %s error %s", path, err)
+	}
+	for _, include := range includesOf(doc) {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return fmt.Errorf("include %s of file %s error %s", include, path, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(include, "*?[") {
+			//the file which is not exist is an error
+			matches = []string{include}
+		}
+		for _, match := range matches {
+			if err := l.load(match, nil); err != nil {
+				return fmt.Errorf("include %s of file %s error %s", match, path, err)
+			}
+		}
 	}
-	<-done
+	l.layers = append(l.layers, &layer{cfg: cfg, doc: doc})
+	return nil
 }
 
-func marshal(v interface{}, ext string) (ret []byte) {
-	if ext == ".json" {
-		ret, _ = json.MarshalIndent(v, "", "\t")
-		return
+//loadDir load the files in directory in lexical order, the hidden files, the sub directories and the files
+//of unknown extensions are skipped
+func (l *fileLoader) loadDir(dir string, forced Codec) error {
+	l.dirs = append(l.dirs, dir)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		path := filepath.Join(dir, name)
+		if strings.HasPrefix(name, ".") || (forced == nil && !hasCodec(filepath.Ext(name))) {
+			continue
+		}
+		//the file may be a symlink
+		if stat, err := os.Stat(path); err != nil || stat.IsDir() {
+			continue
+		}
+		if err := l.load(path, forced); err != nil {
+			return err
+		}
 	}
-	ret, _ = yaml.Marshal(v)
-	return
+	return nil
 }
 
-func unmarshal(in []byte, out interface{}, ext string) error {
-	if ext == ".json" {
-		return json.Unmarshal(in, out)
+//includesOf the files included by the document of file, the value of include can be a path or a list of paths
+func includesOf(doc interface{}) []string {
+	m, _ := toStringMap(doc)
+	var includes []string
+	for _, key := range includeKeys {
+		switch v := m[key].(type) {
+		case string:
+			includes = append(includes, v)
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					includes = append(includes, s)
+				}
+			}
+		}
 	}
-	return yaml.Unmarshal(in, out)
+	return includes
 }
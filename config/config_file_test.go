@@ -0,0 +1,200 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"10-base.yaml":  "addr: \":8080\"\nloglevel: debug\ndatasource:\n  sql: mysql://base\n",
+		"20-cache.json": `{"Addr":":8081","DataSource":{"cache":"redis://cache"}}`,
+		".hidden.yaml":  "addr: \":9999\"\n",
+		"README":        "not a config",
+		"30-empty.yaml": "",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := New(&TestKV{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.InitContext(ctx, URL("file://"+dir+"/")); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cfg := c.GetConfig().(*TestKV)
+	if cfg.Addr != ":8081" || cfg.LogLevel != "debug" || cfg.DataSource["sql"] != "mysql://base" || cfg.DataSource["cache"] != "redis://cache" {
+		t.Fatalf("config %v is not merged in order", cfg)
+	}
+	if err := c.Set("Addr", ":8082"); err == nil {
+		t.Fatal("expect error for saving the config of directory")
+	}
+	//the new file in directory is watched
+	if err := ioutil.WriteFile(filepath.Join(dir, "40-addr.json"), []byte(`{"Addr":":8083"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return c.GetConfig().(*TestKV).Addr == ":8083"
+	})
+}
+
+func TestFileDirNotExist(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "conf.d")
+	c := New(&TestKV{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.InitContext(ctx, URL("file://"+dir+"/"), WithDefault(&TestKV{Addr: ":8080"})); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if addr := c.GetConfig().(*TestKV).Addr; addr != ":8080" {
+		t.Fatalf("addr %s is not the default", addr)
+	}
+	//the directory is created and watched
+	if err := ioutil.WriteFile(filepath.Join(dir, "10-addr.json"), []byte(`{"Addr":":8081"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return c.GetConfig().(*TestKV).Addr == ":8081"
+	})
+}
+
+func TestFileInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml":        "include:\n  - base.json\n  - conf.d/\naddr: \":8080\"\n",
+		"base.json":          `{"Addr":":7070","LogLevel":"info"}`,
+		"conf.d/cache.json":  `{"$include":"../services.yaml","DataSource":{"cache":"redis://cache"}}`,
+		"services.yaml":      "services:\n  - name: serviceA\n",
+		"cycle.yaml":         "include: cycle.yaml\n",
+		"missing.yaml":       "include: [missing.json]\n",
+		"conf.d/.swp":        "",
+		"conf.d/ignored.txt": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := New(&TestKV{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.InitContext(ctx, URL("file://"+filepath.Join(dir, "config.yaml"))); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cfg := c.GetConfig().(*TestKV)
+	if cfg.Addr != ":8080" || cfg.LogLevel != "info" || cfg.DataSource["cache"] != "redis://cache" ||
+		len(cfg.Services) != 1 || cfg.Services[0].Name != "serviceA" {
+		t.Fatalf("config %v does not include the files", cfg)
+	}
+	//the included file is watched
+	if err := ioutil.WriteFile(filepath.Join(dir, "base.json"), []byte(`{"LogLevel":"warn"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return c.GetConfig().(*TestKV).LogLevel == "warn"
+	})
+	for _, name := range []string{"cycle.yaml", "missing.yaml"} {
+		if err := New(&TestKV{}).Init(URL("file://"+filepath.Join(dir, name)), Watch(false), WithLogger(DiscardLogger)); err == nil {
+			t.Fatalf("expect error for %s", name)
+		}
+	}
+}
+
+func TestFileWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	//replace the file by rename like editors
+	replace := func(content string) {
+		t.Helper()
+		tmp := filepath.Join(dir, ".config.json.tmp")
+		if err := ioutil.WriteFile(tmp, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replace(`{"Addr":":8080"}`)
+	c := New(&TestKV{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.InitContext(ctx, URL("file://"+path), WithLogger(DiscardLogger)); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, addr := range []string{":8081", ":8082"} {
+		replace(`{"Addr":"` + addr + `"}`)
+		waitFor(t, func() bool {
+			return c.GetConfig().(*TestKV).Addr == addr
+		})
+	}
+	//the watching is kept after the error of parsing
+	replace(`{"Addr":`)
+	waitFor(t, func() bool {
+		return !c.Status().Healthy
+	})
+	replace(`{"Addr":":8083"}`)
+	waitFor(t, func() bool {
+		return c.GetConfig().(*TestKV).Addr == ":8083" && c.Status().Healthy
+	})
+	if !c.Status().Sources[0].Watching {
+		t.Fatal("file should be watching")
+	}
+	//the watching is stopped with ctx
+	cancel()
+	waitFor(t, func() bool {
+		return !c.Status().Sources[0].Watching
+	})
+}
+
+func TestFileWatchSymlink(t *testing.T) {
+	//the layout of the ConfigMap of Kubernetes, config.json -> ..data/config.json, ..data -> ..v1
+	dir := t.TempDir()
+	publish := func(version, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, version), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, version, "config.json"), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		tmp := filepath.Join(dir, "..data_tmp")
+		if err := os.Symlink(version, tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("..v1", `{"Addr":":8080"}`)
+	path := filepath.Join(dir, "config.json")
+	if err := os.Symlink(filepath.Join("..data", "config.json"), path); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&TestKV{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.InitContext(ctx, URL("file://"+path)); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i, addr := range []string{":8081", ":8082"} {
+		publish("..v"+string(rune('2'+i)), `{"Addr":"`+addr+`"}`)
+		waitFor(t, func() bool {
+			return c.GetConfig().(*TestKV).Addr == addr
+		})
+	}
+}
@@ -1,88 +1,352 @@
 package consul
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"fmt"
 	consul "github.com/hashicorp/consul/api"
-	log "github.com/sirupsen/logrus"
 	"github.com/ti/noframe/config"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
 type consulBackend struct {
 	url      *url.URL
 	client   *consul.Client
-	instance interface{}
+	options  config.Options
 	onLoaded config.OnLoaded
+	watching bool
+	//running and failing the number of the watches which are running and the ones whose blocking queries fail
+	running, failing int
+	//stored the pairs loaded last time by the key of config.KV, they are compared by SaveConfig
+	stored map[string]config.StoredKV
+	mu     sync.Mutex
 }
 
-// New new instance
-func New() *consulBackend {
-	return &consulBackend{}
+const (
+	// waitTime the max time of a blocking query
+	waitTime = 5 * time.Minute
+	// minBackoff and maxBackoff the delay of retry when the blocking query is failed
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// New new backend instance for a config
+func New(o config.Options) (config.Backend, error) {
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &consulBackend{url: u}, nil
 }
 
 func init() {
-	config.AddBackend("consul", &consulBackend{})
+	config.AddBackend("consul", New)
 }
 
-// LoadConfig gets the JSON from ETCD and unmarshal it to the config object
+// LoadConfig gets the kvs from consul and unmarshal it to the config object,
+// the kvs are in the same layout of etcd, which are split by the config tag
 func (c *consulBackend) LoadConfig(o config.Options) error {
 	if o.DefaultConfig == nil {
 		//this should not be happen
 		panic("default config can not be nil")
 	}
 	var err error
-	if c.url == nil {
-		u, err := url.Parse(o.URL)
-		if err != nil {
-			return err
-		}
-		c.url = u
-		c.instance = o.DefaultConfig
-		c.onLoaded = o.OnLoaded
-	}
-	var kv *consul.KVPair
+	c.mu.Lock()
+	c.options = o
+	c.mu.Unlock()
+	c.onLoaded = o.OnLoaded
+	prefixKeys := config.GetPrefixKeys(c.url.Path, o.DefaultConfig)
+	var pairs consul.KVPairs
+	var indexes map[string]uint64
 	if c.client == nil {
-		c.client, err = newClient(c.url)
+		c.client, err = newClient(c.url, o.Log())
 		if err != nil {
 			return err
 		}
-		kv, _, err = c.client.KV().Get(c.url.Path, nil)
+		pairs, indexes, err = c.getPairs(prefixKeys)
 	} else {
-		kv, _, err = c.client.KV().Get(c.url.Path, nil)
+		pairs, indexes, err = c.getPairs(prefixKeys)
 		if err != nil {
-			log.Warnf("etcd get key error ", err, " try 1 time")
-			kv, _, err = c.client.KV().Get(c.url.Path, nil)
+			o.Log().Warn("consul get key error, try 1 time", c.fields(c.url.Path, err))
+			pairs, indexes, err = c.getPairs(prefixKeys)
 		}
 	}
 	if err != nil {
 		return fmt.Errorf("bad cluster endpoints, which are not consul servers: %v", err)
 	}
-	if kv == nil {
-		cnfJson, _ := json.MarshalIndent(c.instance, "", "\t")
-		kv = &consul.KVPair{
-			Key:   c.url.Path,
-			Value: cnfJson,
+	cfg := o.NewConfig()
+	var doc interface{}
+	if len(pairs) == 0 {
+		kvs, err := config.Marshal(c.url.Path, cfg)
+		if err != nil {
+			return fmt.Errorf("path %s marshal error %s", c.url.Path, err)
 		}
-		if _, err := c.client.KV().Put(kv, nil); err != nil {
-			return fmt.Errorf("key not found: %s, put error %s", c.url.Path, err)
+		for _, kv := range kvs {
+			if _, err := c.client.KV().Put(&consul.KVPair{Key: consulKey(kv.Key), Value: []byte(kv.Value)}, nil); err != nil {
+				return fmt.Errorf("key not found: %s, put error %s", c.url.Path, err)
+			}
 		}
+		//read the pairs back for the ModifyIndex of them
+		if pairs, _, err = c.getPairs(prefixKeys); err != nil {
+			return err
+		}
+		c.setStored(pairs)
 	} else {
-		if err := json.Unmarshal([]byte(kv.Value), c.instance); err != nil {
+		c.setStored(pairs)
+		if doc, err = config.UnmarshalDocument(c.url.Path, c.toKvs(pairs), cfg); err != nil {
 			return err
 		}
 	}
-	c.onLoaded(c.instance)
+	if o.Watch && !c.watching {
+		c.watching = true
+		for _, key := range prefixKeys {
+			go c.watch(o.Context, key, prefixKeys, indexes[key])
+		}
+	}
+	return o.LoadedDocument(cfg, doc)
+}
+
+// SaveConfig write the keys of config which are changed in a transaction, every key is checked
+// by its ModifyIndex, it fails if the keys are modified after they are loaded
+func (c *consulBackend) SaveConfig(o config.Options, cfg interface{}) error {
+	kvs, err := config.Marshal(c.url.Path, cfg)
+	if err != nil {
+		return fmt.Errorf("path %s marshal error %s", c.url.Path, err)
+	}
+	c.mu.Lock()
+	stored := c.stored
+	c.mu.Unlock()
+	if revision := config.StoredRevision(stored); o.Revision != 0 && o.Revision != revision {
+		//the pairs are reloaded after the config which is saved is loaded
+		return &config.ErrConflict{Path: c.url.Path, Revision: o.Revision}
+	}
+	changes := config.DiffKVs(stored, kvs, config.GetPrefixKeys(c.url.Path, o.DefaultConfig))
+	if len(changes) == 0 {
+		return nil
+	}
+	ops := make(consul.KVTxnOps, len(changes))
+	for i, change := range changes {
+		//the index 0 means the key should not exist
+		op := &consul.KVTxnOp{Verb: consul.KVCAS, Key: consulKey(change.Key), Value: []byte(change.Value), Index: uint64(change.Revision)}
+		if change.Delete {
+			op = &consul.KVTxnOp{Verb: consul.KVDeleteCAS, Key: consulKey(change.Key), Index: uint64(change.Revision)}
+		}
+		ops[i] = op
+	}
+	ok, resp, _, err := c.client.KV().Txn(ops, (&consul.QueryOptions{}).WithContext(o.Context))
+	if err != nil {
+		return fmt.Errorf("path %s save error %s", c.url.Path, err)
+	}
+	if !ok {
+		return &config.ErrConflict{Path: c.url.Path, Revision: c.Revision()}
+	}
+	indexes := make(map[string]int64, len(resp.Results))
+	for _, pair := range resp.Results {
+		indexes[c.kvKey(pair.Key)] = int64(pair.ModifyIndex)
+	}
+	c.mu.Lock()
+	c.stored = config.SavedKVs(stored, changes, func(key string) int64 {
+		return indexes[key]
+	})
+	c.mu.Unlock()
 	return nil
 }
 
-func newClient(uri *url.URL) (*consul.Client, error) {
+// Revision the max ModifyIndex of the pairs loaded or saved last
+func (c *consulBackend) Revision() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return config.StoredRevision(c.stored)
+}
+
+func (c *consulBackend) setStored(pairs consul.KVPairs) {
+	stored := make(map[string]config.StoredKV, len(pairs))
+	for _, pair := range pairs {
+		stored[c.kvKey(pair.Key)] = config.StoredKV{Value: string(pair.Value), Revision: int64(pair.ModifyIndex)}
+	}
+	c.mu.Lock()
+	c.stored = stored
+	c.mu.Unlock()
+}
+
+//getPairs get the pairs of keys, the keys end with "/" are listed by prefix,
+//it returns the index of every key for blocking queries
+func (c *consulBackend) getPairs(keys []string) (pairs consul.KVPairs, indexes map[string]uint64, err error) {
+	indexes = make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		keyPairs, index, err := c.query(key, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		indexes[key] = index
+		pairs = append(pairs, keyPairs...)
+	}
+	return
+}
+
+//toKvs convert the pairs to config.KV
+func (c *consulBackend) toKvs(pairs consul.KVPairs) []*config.KV {
+	kvs := make([]*config.KV, 0, len(pairs))
+	for _, pair := range pairs {
+		kvs = append(kvs, &config.KV{
+			Key:   c.kvKey(pair.Key),
+			Value: string(pair.Value),
+		})
+	}
+	return kvs
+}
+
+//query get the pairs of key, the key ends with "/" is listed by prefix
+func (c *consulBackend) query(key string, opts *consul.QueryOptions) (consul.KVPairs, uint64, error) {
+	if strings.HasSuffix(key, "/") {
+		pairs, meta, err := c.client.KV().List(consulKey(key), opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		return pairs, meta.LastIndex, nil
+	}
+	pair, meta, err := c.client.KV().Get(consulKey(key), opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if pair == nil {
+		return nil, meta.LastIndex, nil
+	}
+	return consul.KVPairs{pair}, meta.LastIndex, nil
+}
+
+//watch wait for the changes of key by blocking queries, it retries with exponential backoff on errors,
+//all the keys are reloaded when one of them is changed
+func (c *consulBackend) watch(ctx context.Context, key string, keys []string, index uint64) {
+	var failing bool
+	c.reportWatching(1, 0)
+	defer func() {
+		if failing {
+			c.reportWatching(-1, -1)
+		} else {
+			c.reportWatching(-1, 0)
+		}
+	}()
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		opts := (&consul.QueryOptions{WaitIndex: index, WaitTime: waitTime}).WithContext(ctx)
+		if failing {
+			//the query after errors is not blocked, so the recovery of watching is reported at once
+			opts.WaitIndex = 0
+		}
+		_, lastIndex, err := c.query(key, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !failing {
+				failing = true
+				c.reportWatching(0, 1)
+			}
+			fields := c.fields(key, err)
+			fields["backoff"] = backoff.String()
+			c.getOptions().Log().Error("blocking query error, retry after backoff", fields)
+			c.getOptions().ReportError(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+		if failing {
+			failing = false
+			c.reportWatching(0, -1)
+		}
+		if lastIndex < index {
+			//the index of consul may go backwards, the blocking query should be reset
+			index = 0
+			continue
+		}
+		if lastIndex == index {
+			//the blocking query is timeout without change
+			continue
+		}
+		index = lastIndex
+		pairs, _, err := c.getPairs(keys)
+		if err != nil {
+			c.getOptions().Log().Error("get kvs error", c.fields(key, err))
+			c.getOptions().ReportError(err)
+			continue
+		}
+		cfg := c.getOptions().NewConfig()
+		if len(pairs) == 0 {
+			//the source is empty, no key of it overrides the default config or the former sources
+			c.getOptions().Log().Warn("keys are deleted, the default config is used", c.fields(key, nil))
+			//the keys are created by SaveConfig as new ones
+			c.setStored(nil)
+			c.getOptions().LoadedDocument(cfg, map[string]interface{}{})
+			continue
+		}
+		doc, err := config.UnmarshalDocument(c.url.Path, c.toKvs(pairs), cfg)
+		if err != nil {
+			c.getOptions().Log().Error("unmarshal error", c.fields(key, err))
+			c.getOptions().ReportError(err)
+			continue
+		}
+		c.setStored(pairs)
+		c.getOptions().LoadedDocument(cfg, doc)
+	}
+}
+
+//reportWatching add the number of running and failing watches, the keys are watched if every watch is running
+//without failure
+func (c *consulBackend) reportWatching(running, failing int) {
+	c.mu.Lock()
+	c.running += running
+	c.failing += failing
+	watching := c.running > 0 && c.failing == 0
+	options := c.options
+	c.mu.Unlock()
+	options.ReportWatching(watching)
+}
+
+//getOptions return the options of the last load, which is replaced by every reload
+func (c *consulBackend) getOptions() config.Options {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.options
+}
+
+//fields the fields of log, the error is omitted if it is nil
+func (c *consulBackend) fields(key string, err error) config.Fields {
+	fields := config.Fields{"scheme": "consul", "key": key, "revision": c.Revision()}
+	if err != nil {
+		fields["error"] = err
+	}
+	return fields
+}
+
+//consulKey the key of consul never starts with "/"
+func consulKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+//kvKey the key of config.KV starts with "/" if the url path does
+func (c *consulBackend) kvKey(key string) string {
+	if strings.HasPrefix(c.url.Path, "/") && !strings.HasPrefix(key, "/") {
+		return "/" + key
+	}
+	return key
+}
+
+func newClient(uri *url.URL, logger config.Logger) (*consul.Client, error) {
 	cfg := consul.DefaultConfig()
 	cfg.Address = uri.Host
 	uriQuery := uri.Query()
@@ -101,7 +365,7 @@ func newClient(uri *url.URL) (*consul.Client, error) {
 			return nil, err
 		}
 		if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
-			log.Warning("No certs appended, using system certs only")
+			logger.Warn("no certs appended, using system certs only", config.Fields{"scheme": "consul", "cert": cert})
 		}
 
 		tlsConfig := &tls.Config{
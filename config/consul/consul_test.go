@@ -0,0 +1,357 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/ti/noframe/config"
+)
+
+type testConfig struct {
+	Addr       string
+	LogLevel   string
+	DataSource map[string]string `config:"data_source/"`
+}
+
+//fakeConsul the kv api of consul with blocking queries and transactions, every change moves the index
+type fakeConsul struct {
+	mu      sync.Mutex
+	index   uint64
+	kvs     map[string]*consul.KVPair
+	changed chan struct{}
+	//failures the number of blocking queries to fail
+	failures int
+	//waits the indexes of the blocking queries, blocking is the number of queries which are blocked
+	waits    []uint64
+	blocking int
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, string) {
+	f := &fakeConsul{index: 1, kvs: make(map[string]*consul.KVPair), changed: make(chan struct{})}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, strings.TrimPrefix(server.URL, "http://")
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	switch {
+	case r.URL.Path == "/v1/txn" && r.Method == "PUT":
+		f.txn(w, r)
+	case key == r.URL.Path:
+		http.NotFound(w, r)
+	case r.Method == "GET":
+		f.get(w, r, key)
+	case r.Method == "PUT":
+		b, _ := ioutil.ReadAll(r.Body)
+		f.set(key, string(b))
+		w.Write([]byte("true"))
+	case r.Method == "DELETE":
+		f.delete(key, r.URL.Query().Has("recurse"))
+		w.Write([]byte("true"))
+	}
+}
+
+func (f *fakeConsul) get(w http.ResponseWriter, r *http.Request, key string) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	f.mu.Lock()
+	if index > 0 {
+		f.waits = append(f.waits, index)
+		if f.failures > 0 {
+			f.failures--
+			f.mu.Unlock()
+			http.Error(w, "no cluster leader", http.StatusInternalServerError)
+			return
+		}
+	}
+	//the query is blocked until the index is moved, it is returned at once if the index goes backwards
+	for index > 0 && f.index == index {
+		changed := f.changed
+		f.blocking++
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+		}
+		f.mu.Lock()
+		f.blocking--
+		if r.Context().Err() != nil {
+			f.mu.Unlock()
+			return
+		}
+	}
+	var pairs consul.KVPairs
+	for k, pair := range f.kvs {
+		if k == key || (r.URL.Query().Has("recurse") && strings.HasPrefix(k, key)) {
+			pairs = append(pairs, pair)
+		}
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	f.mu.Unlock()
+	if len(pairs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(pairs)
+}
+
+//txn apply the cas and delete-cas operations, all of them fail if one of them fails
+func (f *fakeConsul) txn(w http.ResponseWriter, r *http.Request) {
+	var ops consul.TxnOps
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var resp consul.TxnResponse
+	for i, op := range ops {
+		old := f.kvs[op.KV.Key]
+		switch {
+		case op.KV.Verb == consul.KVCAS && op.KV.Index == 0 && old == nil:
+		case (op.KV.Verb == consul.KVCAS || op.KV.Verb == consul.KVDeleteCAS) && old != nil && old.ModifyIndex == op.KV.Index:
+		default:
+			resp.Errors = append(resp.Errors, &consul.TxnError{OpIndex: i, What: "index is not matched"})
+		}
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	if len(resp.Errors) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	f.index++
+	for _, op := range ops {
+		if op.KV.Verb == consul.KVDeleteCAS {
+			delete(f.kvs, op.KV.Key)
+			continue
+		}
+		f.kvs[op.KV.Key] = &consul.KVPair{Key: op.KV.Key, Value: op.KV.Value, ModifyIndex: f.index}
+		resp.Results = append(resp.Results, &consul.TxnResult{KV: &consul.KVPair{Key: op.KV.Key, ModifyIndex: f.index}})
+	}
+	f.notify()
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeConsul) set(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.kvs[key] = &consul.KVPair{Key: key, Value: []byte(value), ModifyIndex: f.index}
+	f.notify()
+}
+
+func (f *fakeConsul) delete(key string, recurse bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k := range f.kvs {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			delete(f.kvs, k)
+		}
+	}
+	f.index++
+	f.notify()
+}
+
+//notify wake the blocking queries, it should be called with lock
+func (f *fakeConsul) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+//state the state of fake consul under lock
+func (f *fakeConsul) state(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not met before timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	f, host := newFakeConsul(t)
+	f.set("app/config", `{"Addr":":8080","LogLevel":"debug"}`)
+	f.set("app/config/data_source/sql", `"mysql://sql"`)
+	c := config.New(&testConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.InitContext(ctx, config.URL("consul://"+host+"/app/config"), config.WithDefault(&testConfig{LogLevel: "info"}),
+		config.WithLogger(config.DiscardLogger)); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if cfg := c.GetConfig().(*testConfig); cfg.Addr != ":8080" || cfg.DataSource["sql"] != "mysql://sql" {
+		t.Fatalf("config %v does not match expect", cfg)
+	}
+	//every key is watched from the index it is loaded at
+	blockedAt := func(index uint64) func() bool {
+		return func() bool {
+			var ok bool
+			f.state(func() {
+				ok = f.blocking == 2 && len(f.waits) >= 2 && f.waits[len(f.waits)-1] == index && f.waits[len(f.waits)-2] == index
+			})
+			return ok
+		}
+	}
+	waitFor(t, blockedAt(3))
+	watching := func(expect bool) func() bool {
+		return func() bool {
+			return c.Status().Sources[0].Watching == expect
+		}
+	}
+	waitFor(t, watching(true))
+	f.set("app/config", `{"Addr":":8081","LogLevel":"debug"}`)
+	waitFor(t, func() bool {
+		return c.GetConfig().(*testConfig).Addr == ":8081"
+	})
+	waitFor(t, blockedAt(4))
+	//the blocking queries are retried after the errors
+	f.state(func() {
+		f.failures = 2
+	})
+	f.set("app/config/data_source/sql", `"mysql://sql2"`)
+	//the watching is broken until the blocking queries succeed again
+	waitFor(t, watching(false))
+	waitFor(t, func() bool {
+		return c.GetConfig().(*testConfig).DataSource["sql"] == "mysql://sql2"
+	})
+	waitFor(t, blockedAt(5))
+	waitFor(t, watching(true))
+	if status := c.Status(); status.Sources[0].LastError == "" {
+		t.Fatalf("the errors of watching should be reported, status %+v", status)
+	}
+	//the index goes backwards, exp: the data of consul is restored, the blocking queries are reset
+	f.state(func() {
+		f.index = 2
+		f.notify()
+	})
+	waitFor(t, blockedAt(2))
+	//the config falls back to the default one when every key is deleted
+	f.delete("app/config", true)
+	waitFor(t, func() bool {
+		cfg := c.GetConfig().(*testConfig)
+		return cfg.Addr == "" && cfg.LogLevel == "info" && len(cfg.DataSource) == 0
+	})
+	waitFor(t, blockedAt(3))
+	//the blocking queries are stopped by ctx
+	cancel()
+	waitFor(t, func() bool {
+		var blocking int
+		f.state(func() {
+			blocking = f.blocking
+		})
+		return blocking == 0
+	})
+	waitFor(t, watching(false))
+	var waits int
+	f.state(func() {
+		waits = len(f.waits)
+		f.notify()
+	})
+	time.Sleep(100 * time.Millisecond)
+	f.state(func() {
+		if len(f.waits) != waits {
+			t.Errorf("blocking queries %v are sent after ctx is done", f.waits[waits:])
+		}
+	})
+}
+
+//TestWatchWhilePolling the options of backend are replaced by every poll while the blocking queries use them
+func TestWatchWhilePolling(t *testing.T) {
+	f, host := newFakeConsul(t)
+	f.set("app/config", `{"Addr":":8080"}`)
+	c := config.New(&testConfig{})
+	if err := c.Init(config.URL("consul://"+host+"/app/config?ttl=3s&watch=true"), config.WithDefault(&testConfig{}),
+		config.WithLogger(config.DiscardLogger)); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	loaded := c.Status().Sources[0].LastLoaded
+	waitFor(t, func() bool {
+		status := c.Status().Sources[0]
+		return status.Watching && status.LastLoaded.After(loaded)
+	})
+	f.set("app/config", `{"Addr":":8081"}`)
+	waitFor(t, func() bool {
+		return c.GetConfig().(*testConfig).Addr == ":8081"
+	})
+}
+
+func TestSaveConfig(t *testing.T) {
+	f, host := newFakeConsul(t)
+	f.set("app/config", `{"Addr":":8080","LogLevel":"debug"}`)
+	f.set("app/config/data_source/sql", `"mysql://sql"`)
+	f.set("app/config/data_source/cache", `"redis://cache"`)
+	uri := "consul://" + host + "/app/config"
+	c := config.New(&testConfig{})
+	if err := c.Init(config.URL(uri), config.WithDefault(&testConfig{}), config.Watch(false)); err != nil {
+		t.Fatal(err)
+	}
+	//the changed keys are written by cas, the removed items of map are deleted by delete-cas
+	err := c.Update(func(cfg interface{}) error {
+		kv := cfg.(*testConfig)
+		kv.Addr = ":8081"
+		delete(kv.DataSource, "cache")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.state(func() {
+		if _, ok := f.kvs["app/config/data_source/cache"]; ok || !strings.Contains(string(f.kvs["app/config"].Value), ":8081") {
+			t.Fatalf("kvs %v are not saved", f.kvs)
+		}
+	})
+	//the key is modified by others after it is loaded
+	f.set("app/config/data_source/sql", `"mysql://others"`)
+	err = c.Update(func(cfg interface{}) error {
+		cfg.(*testConfig).DataSource["sql"] = "mysql://mine"
+		return nil
+	})
+	if !config.IsConflict(err) {
+		t.Fatalf("expect ErrConflict, got %v", err)
+	}
+	f.state(func() {
+		if value := string(f.kvs["app/config/data_source/sql"].Value); value != `"mysql://others"` {
+			t.Fatalf("value %s should not be overwritten by conflict write", value)
+		}
+	})
+	//the keys are created again after all of them are deleted
+	watched := config.New(&testConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := watched.InitContext(ctx, config.URL(uri), config.WithDefault(&testConfig{}), config.WithLogger(config.DiscardLogger)); err != nil {
+		t.Fatal(err)
+	}
+	defer watched.Close()
+	f.delete("app/config", true)
+	waitFor(t, func() bool {
+		return watched.GetConfig().(*testConfig).Addr == "" && watched.Revision() == 0
+	})
+	if err := watched.Set("Addr", ":8082"); err != nil {
+		t.Fatal(err)
+	}
+	f.state(func() {
+		if pair := f.kvs["app/config"]; pair == nil || !strings.Contains(string(pair.Value), ":8082") {
+			t.Fatalf("kvs %v are not created", f.kvs)
+		}
+	})
+}
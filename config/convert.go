@@ -0,0 +1,130 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+//setString convert the string to the kind of the value and set it,
+//slices are separated by ",", maps are "k=v" pairs separated by ","
+func setString(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch k := v.Kind(); k {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setString(v.Elem(), s)
+	case reflect.Slice:
+		if !isScalar(v.Type().Elem()) {
+			return json.Unmarshal([]byte(s), v.Addr().Interface())
+		}
+		var parts []string
+		if s != "" {
+			parts = strings.Split(s, ",")
+		}
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setString(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || !isScalar(v.Type().Elem()) {
+			return json.Unmarshal([]byte(s), v.Addr().Interface())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for _, part := range strings.Split(s, ",") {
+			if part == "" {
+				continue
+			}
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("%s is not a k=v pair", part)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setString(elem, strings.TrimSpace(kv[1])); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(strings.TrimSpace(kv[0])).Convert(v.Type().Key()), elem)
+		}
+	case reflect.Struct, reflect.Interface:
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	default:
+		return fmt.Errorf("%s is not supported", k)
+	}
+	return nil
+}
+
+//isScalar the type can be converted from a simple string
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Ptr:
+		return isScalar(t.Elem())
+	}
+	return false
+}
+
+//fieldNames the names of struct field, they are the field name, the json tag and the config tag,
+//the config tag is skipped if it is a kv path
+func fieldNames(f reflect.StructField) []string {
+	names := []string{f.Name}
+	if t := f.Tag.Get("json"); t != "" && t != "-" {
+		if name := getFiledTag("json", f); name != "" && name != f.Name {
+			names = append(names, name)
+		}
+	}
+	if t := f.Tag.Get(tagName); t != "" {
+		if name := getFiledTag(tagName, f); name != "" && !strings.Contains(name, "/") && name != f.Name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+//ChangeKind the kind of a changed path
+type ChangeKind string
+
+const (
+	//ChangeAdded the item of map or slice is added
+	ChangeAdded ChangeKind = "added"
+	//ChangeRemoved the item of map or slice is removed
+	ChangeRemoved ChangeKind = "removed"
+	//ChangeModified the value is modified
+	ChangeModified ChangeKind = "modified"
+)
+
+//Change a changed path of config, the path is in the syntax of SetFieldListener, exp: Services[0].Hooks.Url
+type Change struct {
+	Path string
+	Old  interface{}
+	New  interface{}
+	Kind ChangeKind
+}
+
+//Diff get the changed leaves from old to new in order of fields, slice indexes and sorted map keys,
+//the items of maps and slices are added or removed, other leaves are modified,
+//exp: Diff(nil, cfg) is a single added change of the root path ""
+func Diff(old, new interface{}) []Change {
+	var changes []Change
+	diffValue("", reflect.ValueOf(old), reflect.ValueOf(new), &changes)
+	return changes
+}
+
+func diffValue(path string, old, new reflect.Value, changes *[]Change) {
+	switch {
+	case !old.IsValid() && !new.IsValid():
+		return
+	case !old.IsValid():
+		*changes = append(*changes, Change{Path: path, New: new.Interface(), Kind: ChangeAdded})
+		return
+	case !new.IsValid():
+		*changes = append(*changes, Change{Path: path, Old: old.Interface(), Kind: ChangeRemoved})
+		return
+	case old.Type() != new.Type():
+		*changes = append(*changes, Change{Path: path, Old: old.Interface(), New: new.Interface(), Kind: ChangeModified})
+		return
+	}
+	switch old.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !old.IsNil() && !new.IsNil() {
+			diffValue(path, old.Elem(), new.Elem(), changes)
+			return
+		}
+	case reflect.Struct:
+		if old.Type() == durationType || !hasExportedField(old.Type()) {
+			break
+		}
+		t := old.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if f.Anonymous {
+				//the fields of embedded struct are promoted
+				diffValue(path, old.Field(i), new.Field(i), changes)
+				continue
+			}
+			diffValue(joinPath(path, f.Name), old.Field(i), new.Field(i), changes)
+		}
+		return
+	case reflect.Slice, reflect.Array:
+		n := old.Len()
+		if new.Len() > n {
+			n = new.Len()
+		}
+		for i := 0; i < n; i++ {
+			var o, v reflect.Value
+			if i < old.Len() {
+				o = old.Index(i)
+			}
+			if i < new.Len() {
+				v = new.Index(i)
+			}
+			diffValue(path+"["+strconv.Itoa(i)+"]", o, v, changes)
+		}
+		return
+	case reflect.Map:
+		if old.Type().Key().Kind() != reflect.String {
+			break
+		}
+		for _, k := range mapKeys(old, new) {
+			diffValue(joinPath(path, k.String()), old.MapIndex(k), new.MapIndex(k), changes)
+		}
+		return
+	}
+	if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+		*changes = append(*changes, Change{Path: path, Old: old.Interface(), New: new.Interface(), Kind: ChangeModified})
+	}
+}
+
+//mapKeys the sorted keys of both maps
+func mapKeys(a, b reflect.Value) []reflect.Value {
+	seen := make(map[string]bool)
+	var keys []reflect.Value
+	for _, m := range []reflect.Value{a, b} {
+		for _, k := range m.MapKeys() {
+			if !seen[k.String()] {
+				seen[k.String()] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+func hasExportedField(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return true
+		}
+	}
+	return false
+}
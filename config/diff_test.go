@@ -0,0 +1,51 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := cloneInstance(testKV).(TestKV)
+	cfg := cloneInstance(testKV).(TestKV)
+	cfg.Addr = ":8081"
+	cfg.Services = cfg.Services[:1]
+	cfg.Services[0].Hooks.Url = "http://hooks"
+	cfg.DataSource = map[string]string{"sql": old.DataSource["sql"], "redis": "redis://127.0.0.1"}
+	changes := Diff(old, cfg)
+	expect := []Change{
+		{Path: "Addr", Old: old.Addr, New: ":8081", Kind: ChangeModified},
+		{Path: "DataSource.cache", Old: old.DataSource["cache"], Kind: ChangeRemoved},
+		{Path: "DataSource.redis", New: "redis://127.0.0.1", Kind: ChangeAdded},
+		{Path: "Services[0].Hooks.Url", Old: old.Services[0].Hooks.Url, New: "http://hooks", Kind: ChangeModified},
+		{Path: "Services[1]", Old: old.Services[1], Kind: ChangeRemoved},
+	}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("changes %+v does not match expect %+v", changes, expect)
+	}
+	for _, change := range []Change{changes[0], changes[3]} {
+		v, err := getFieldValue(cfg, change.Path)
+		if err != nil || v != change.New {
+			t.Fatalf("path %s should be parsed by compile, got %v %v", change.Path, v, err)
+		}
+	}
+	if len(Diff(old, cloneInstance(old))) != 0 {
+		t.Fatal("expect no changes for same config")
+	}
+}
+
+func TestChangeListener(t *testing.T) {
+	c := New(&TestKV{})
+	var received [][]Change
+	c.SetChangeListener(func(changes []Change) {
+		received = append(received, changes)
+	})
+	initCas(t, c, nil)
+	if err := c.Set("LogLevel", "info"); err != nil {
+		t.Fatal(err)
+	}
+	expect := []Change{{Path: "LogLevel", Old: testKV.LogLevel, New: "info", Kind: ChangeModified}}
+	if len(received) != 2 || received[0][0].Kind != ChangeAdded || !reflect.DeepEqual(received[1], expect) {
+		t.Fatalf("changes %+v does not match expect %+v", received, expect)
+	}
+}
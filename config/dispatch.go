@@ -0,0 +1,168 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//event the changes of a reload, it is delivered to the listeners which are registered when it is reloaded
+type event struct {
+	pre, cfg               interface{}
+	preInstance, newConfig interface{}
+	changes                []Change
+	listeners              []*listener
+	onChanges              OnChanges
+}
+
+//dispatch deliver the event outside of the lock, the events are delivered in the order of reloads,
+//they are queued to the dispatcher if WithAsyncListeners is set
+func (c *Config) dispatch(ev *event) {
+	c.mu.Lock()
+	if c.queue != nil && !c.queueStopped {
+		if len(c.overflow) == 0 {
+			select {
+			case c.queue <- ev:
+				c.mu.Unlock()
+				return
+			default:
+			}
+		}
+		//the queue is full, the send is not blocked, exp: a listener on the dispatcher calls Update
+		c.overflow = append(c.overflow, ev)
+		c.mu.Unlock()
+		return
+	}
+	//the dispatcher is stopped or not used, deliver it in place
+	c.pending = append(c.pending, ev)
+	if c.dispatching {
+		//the event is delivered by the goroutine which is dispatching, exp: Update is called in a listener
+		c.mu.Unlock()
+		return
+	}
+	c.dispatching = true
+	c.mu.Unlock()
+	c.deliverPending()
+}
+
+//holdDispatch keep the events delivered in place pending until deliverPending is called,
+//it returns false if the events are being delivered by others
+func (c *Config) holdDispatch() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dispatching {
+		return false
+	}
+	c.dispatching = true
+	return true
+}
+
+//deliverPending deliver the pending events in order until there is none, it is called by the goroutine
+//which sets dispatching
+func (c *Config) deliverPending() {
+	for {
+		c.mu.Lock()
+		if len(c.pending) == 0 {
+			c.dispatching = false
+			c.mu.Unlock()
+			return
+		}
+		ev := c.pending[0]
+		c.pending = c.pending[1:]
+		c.mu.Unlock()
+		c.deliver(ev)
+	}
+}
+
+//runDispatcher deliver the events in queue until ctx is done
+func (c *Config) runDispatcher(ctx context.Context, queue chan *event) {
+	defer c.wg.Done()
+	for {
+		if ev := c.nextQueued(queue, false); ev != nil {
+			c.deliver(ev)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			//the events which are queued before it is stopped are still delivered
+			for ev := c.nextQueued(queue, true); ev != nil; ev = c.nextQueued(queue, true) {
+				c.deliver(ev)
+			}
+			return
+		case ev := <-queue:
+			c.deliver(ev)
+		}
+	}
+}
+
+//nextQueued the next event in queue or overflow without blocking, the events in queue are sent before the ones
+//in overflow. The dispatcher is marked stopped if stop is true and there is no event
+func (c *Config) nextQueued(queue chan *event, stop bool) *event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case ev := <-queue:
+		return ev
+	default:
+	}
+	if len(c.overflow) > 0 {
+		ev := c.overflow[0]
+		c.overflow = c.overflow[1:]
+		return ev
+	}
+	if stop {
+		c.queueStopped = true
+	}
+	return nil
+}
+
+func (c *Config) deliver(ev *event) {
+	for _, l := range ev.listeners {
+		l.notify(c, ev)
+	}
+	if ev.onChanges != nil && len(ev.changes) > 0 {
+		c.invoke("", 0, func() {
+			ev.onChanges(ev.changes)
+		})
+	}
+}
+
+//invoke call the listener of path, the panic of it is recovered and reported to the error listener,
+//it stops waiting for the listener after timeout if timeout is set
+func (c *Config) invoke(path string, timeout time.Duration, fn func()) {
+	run := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.reportError(fmt.Errorf("listener of %q panics: %v", path, r))
+			}
+		}()
+		fn()
+	}
+	if timeout <= 0 {
+		run()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		c.reportError(fmt.Errorf("listener of %q is timeout after %s", path, timeout))
+	}
+}
+
+//reportError log the error and call the error listener
+func (c *Config) reportError(err error) {
+	c.log().Error(err.Error(), nil)
+	c.mu.Lock()
+	onError := c.onError
+	c.mu.Unlock()
+	if onError != nil {
+		onError(err)
+	}
+}
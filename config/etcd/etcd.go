@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"go.etcd.io/etcd/v3/mvcc/mvccpb"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/ti/noframe/config"
 	"go.etcd.io/etcd/v3/clientv3"
 	"go.etcd.io/etcd/v3/pkg/transport"
@@ -16,24 +18,43 @@ import (
 
 type etcdBackend struct {
 	url      *url.URL
-	instance interface{}
+	options  config.Options
 	onLoaded config.OnLoaded
+	client   *clientv3.Client
+	//stored the kvs loaded last time, they are compared by SaveConfig
+	stored map[string]config.StoredKV
+	//rev the past revision to load, it is set by the rev query of url, the config is not watched for it
+	rev int64
+	mu  sync.Mutex
 }
 
-// New new instance
-func New() *etcdBackend {
-	return &etcdBackend{}
+// New new backend instance for a config, a past revision is loaded if the rev query is set,
+// exp: etcd://127.0.0.1:2379/xl/config/key?rev=100
+func New(o config.Options) (config.Backend, error) {
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return nil, err
+	}
+	var rev int64
+	if r := u.Query().Get("rev"); r != "" {
+		if rev, err = strconv.ParseInt(r, 10, 64); err != nil {
+			return nil, fmt.Errorf("rev %s is not a revision", r)
+		}
+	}
+	return &etcdBackend{url: u, rev: rev}, nil
 }
 
-var client *clientv3.Client
+var lastClient atomic.Value
 
-//GetEtcd get ETCD client
+//GetEtcd get ETCD client of the backend which is created last
+//Deprecated: every config has its own client, use the client of your own
 func GetEtcd() *clientv3.Client {
+	client, _ := lastClient.Load().(*clientv3.Client)
 	return client
 }
 
 func init() {
-	config.AddBackend("etcd", &etcdBackend{})
+	config.AddBackend("etcd", New)
 }
 
 // LoadConfig gets the JSON from ETCD and unmarshals it to the config object
@@ -44,20 +65,16 @@ func (e *etcdBackend) LoadConfig(o config.Options) error {
 	}
 	var err error
 	var newEtcd bool
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(o.Context, 15*time.Second)
 	defer cancel()
-	if e.url == nil {
-		u, err := url.Parse(o.URL)
-		if err != nil {
-			return err
-		}
-		e.url = u
-		e.instance = o.DefaultConfig
-		e.onLoaded = o.OnLoaded
-	}
+	e.mu.Lock()
+	e.options = o
+	e.mu.Unlock()
+	e.onLoaded = o.OnLoaded
+	client := e.getClient()
 	if client == nil {
 		//first time to load config
-		client, err = newEtcdClient(e.url)
+		client, err = e.newClient()
 		if err != nil {
 			return err
 		}
@@ -68,9 +85,8 @@ func (e *etcdBackend) LoadConfig(o config.Options) error {
 	if err != nil {
 		if !newEtcd {
 			client.Close()
-			client = nil
-			log.Warnf("etcd get key error ", err, " try 1 time")
-			client, err = newEtcdClient(e.url)
+			o.Log().Warn("etcd get key error, try 1 time", e.fields(err))
+			client, err = e.newClient()
 			if err != nil {
 				return err
 			}
@@ -82,17 +98,26 @@ func (e *etcdBackend) LoadConfig(o config.Options) error {
 		return fmt.Errorf("bad cluster endpoints, which are not etcd servers: %v", err)
 	}
 
+	cfg := o.NewConfig()
+	var doc interface{}
+	if len(etcdKvs) == 0 && e.rev > 0 {
+		return fmt.Errorf("key not found: %s at revision %d", e.url.Path, e.rev)
+	}
 	if len(etcdKvs) == 0 {
-		kvs, err := config.Marshal(e.url.Path, e.instance)
+		kvs, err := config.Marshal(e.url.Path, cfg)
 		if err != nil {
 			return fmt.Errorf("path %s marshal error %s", e.url.Path, err)
 		}
 		for _, kv := range kvs {
-			if _, err := client.Put(ctx, kv.Key, kv.Value); err != nil {
+			resp, err := client.Put(ctx, kv.Key, kv.Value)
+			if err != nil {
 				return fmt.Errorf("key not found: %s, put error %s", e.url.Path, err)
 			}
+			etcdKvs = append(etcdKvs, &mvccpb.KeyValue{Key: []byte(kv.Key), Value: []byte(kv.Value), ModRevision: resp.Header.Revision})
 		}
+		e.setStored(etcdKvs)
 	} else {
+		e.setStored(etcdKvs)
 		var kvs []*config.KV
 		for _, kv := range etcdKvs {
 			kvs = append(kvs, &config.KV{
@@ -100,30 +125,145 @@ func (e *etcdBackend) LoadConfig(o config.Options) error {
 				Value: string(kv.Value),
 			})
 		}
-		err = config.Unmarshal(e.url.Path, kvs, e.instance)
+		doc, err = config.UnmarshalDocument(e.url.Path, kvs, cfg)
 		if err != nil {
 			return err
 		}
 	}
-	watch := o.Watch && e.onLoaded != nil
+	watch := o.Watch && e.onLoaded != nil && e.rev == 0
 	if newEtcd && watch {
-		go e.watch(context.Background(), e.url.Path, prefixKeys)
+		go e.watch(o.Context, e.url.Path, prefixKeys)
 	}
 	if !watch {
-		client.Close()
-		client = nil
+		e.Close()
+	}
+	return o.LoadedDocument(cfg, doc)
+}
+
+// SaveConfig write the keys of config which are changed in a transaction,
+// it fails if the keys are modified after they are loaded
+func (e *etcdBackend) SaveConfig(o config.Options, cfg interface{}) error {
+	kvs, err := config.Marshal(e.url.Path, cfg)
+	if err != nil {
+		return fmt.Errorf("path %s marshal error %s", e.url.Path, err)
+	}
+	client := e.getClient()
+	if client == nil {
+		//the client is closed after loading when it is not watching
+		client, err = e.newClient()
+		if err != nil {
+			return err
+		}
+		defer e.Close()
+	}
+	e.mu.Lock()
+	stored := e.stored
+	e.mu.Unlock()
+	if revision := config.StoredRevision(stored); o.Revision != 0 && o.Revision != revision {
+		//the kvs are reloaded after the config which is saved is loaded
+		return &config.ErrConflict{Path: e.url.Path, Revision: o.Revision}
+	}
+	changes := config.DiffKVs(stored, kvs, config.GetPrefixKeys(e.url.Path, o.DefaultConfig))
+	if len(changes) == 0 {
+		return nil
+	}
+	cmps := make([]clientv3.Cmp, len(changes))
+	ops := make([]clientv3.Op, len(changes))
+	for i, change := range changes {
+		//the ModRevision 0 means the key should not exist
+		cmps[i] = clientv3.Compare(clientv3.ModRevision(change.Key), "=", change.Revision)
+		if change.Delete {
+			ops[i] = clientv3.OpDelete(change.Key)
+		} else {
+			ops[i] = clientv3.OpPut(change.Key, change.Value)
+		}
 	}
-	e.onLoaded(e.instance)
+	ctx, cancel := context.WithTimeout(o.Context, 15*time.Second)
+	defer cancel()
+	resp, err := client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return fmt.Errorf("path %s save error %s", e.url.Path, err)
+	}
+	if !resp.Succeeded {
+		return &config.ErrConflict{Path: e.url.Path, Revision: e.Revision()}
+	}
+	e.mu.Lock()
+	e.stored = config.SavedKVs(stored, changes, func(string) int64 {
+		return resp.Header.Revision
+	})
+	e.mu.Unlock()
 	return nil
 }
 
+func (e *etcdBackend) setStored(kvs []*mvccpb.KeyValue) {
+	stored := make(map[string]config.StoredKV, len(kvs))
+	for _, kv := range kvs {
+		stored[string(kv.Key)] = config.StoredKV{Value: string(kv.Value), Revision: kv.ModRevision}
+	}
+	e.mu.Lock()
+	e.stored = stored
+	e.mu.Unlock()
+}
+
+// Revision the max ModRevision of the kvs loaded or saved last
+func (e *etcdBackend) Revision() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return config.StoredRevision(e.stored)
+}
+
+//fields the fields of log
+func (e *etcdBackend) fields(err error) config.Fields {
+	return config.Fields{"scheme": "etcd", "key": e.url.Path, "revision": e.Revision(), "error": err}
+}
+
+// Close close the etcd client, the watching is stopped with it
+func (e *etcdBackend) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.client == nil {
+		return nil
+	}
+	err := e.client.Close()
+	e.client = nil
+	return err
+}
+
+func (e *etcdBackend) newClient() (*clientv3.Client, error) {
+	client, err := newEtcdClient(e.url)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	e.client = client
+	e.mu.Unlock()
+	lastClient.Store(client)
+	return client, nil
+}
+
+//getOptions return the options of the last load, which is replaced by every reload
+func (e *etcdBackend) getOptions() config.Options {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.options
+}
+
+func (e *etcdBackend) getClient() *clientv3.Client {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.client
+}
+
 func (e *etcdBackend) getKvs(ctx context.Context, keys []string) (kvs []*mvccpb.KeyValue, err error) {
 	for _, key := range keys {
 		var opts []clientv3.OpOption
 		if strings.HasSuffix(key, "/") {
 			opts = append(opts, clientv3.WithPrefix())
 		}
-		getResp, err := client.Get(ctx, key, opts...)
+		if e.rev > 0 {
+			opts = append(opts, clientv3.WithRev(e.rev))
+		}
+		getResp, err := e.getClient().Get(ctx, key, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -135,6 +275,7 @@ func (e *etcdBackend) getKvs(ctx context.Context, keys []string) (kvs []*mvccpb.
 }
 
 func (e *etcdBackend) watch(ctx context.Context, rootKey string, keys []string) {
+	e.getOptions().ReportWatching(true)
 	var watchKeys []string
 	var watchRootKeys []string
 	for _, k := range keys {
@@ -149,21 +290,24 @@ func (e *etcdBackend) watch(ctx context.Context, rootKey string, keys []string)
 		if strings.HasSuffix(key, "/") {
 			opts = append(opts, clientv3.WithPrefix())
 		}
-		wc := client.Watch(ctx, key, opts...)
+		wc := e.getClient().Watch(ctx, key, opts...)
 		go e.onEtcdWatch(ctx, keys, wc)
 	}
 	var opts []clientv3.OpOption
 	if len(watchRootKeys) > 1 || strings.HasSuffix(e.url.Path, "/") {
 		opts = append(opts, clientv3.WithPrefix())
 	}
-	wc := client.Watch(ctx, e.url.Path, opts...)
+	wc := e.getClient().Watch(ctx, e.url.Path, opts...)
 	e.onEtcdWatch(ctx, keys, wc)
 }
 
+//onEtcdWatch reload the config on the events of wc, the watching is broken when it returns
 func (e *etcdBackend) onEtcdWatch(ctx context.Context, keys []string, wc clientv3.WatchChan) {
+	defer e.getOptions().ReportWatching(false)
 	for wresp := range wc {
 		if wresp.Err() != nil {
-			log.Errorf("Watch channel returned err %v", wresp.Err())
+			e.getOptions().Log().Error("watch channel returned error", e.fields(wresp.Err()))
+			e.getOptions().ReportError(wresp.Err())
 			return
 		}
 		var isChange bool
@@ -175,9 +319,11 @@ func (e *etcdBackend) onEtcdWatch(ctx context.Context, keys []string, wc clientv
 		if isChange {
 			etcdKvs, err := e.getKvs(ctx, keys)
 			if err != nil {
-				log.Errorf("Watch channel get prefix err %v", err)
+				e.getOptions().Log().Error("watch channel get prefix error", e.fields(err))
+				e.getOptions().ReportError(err)
 				continue
 			}
+			e.setStored(etcdKvs)
 			var kvs []*config.KV
 			for _, kv := range etcdKvs {
 				kvs = append(kvs, &config.KV{
@@ -185,11 +331,13 @@ func (e *etcdBackend) onEtcdWatch(ctx context.Context, keys []string, wc clientv
 					Value: string(kv.Value),
 				})
 			}
-			if err := config.Unmarshal(e.url.Path, kvs, e.instance); err != nil {
-				log.Errorf("Watch channel unmarshal err %s", err.Error())
+			cfg := e.getOptions().NewConfig()
+			if doc, err := config.UnmarshalDocument(e.url.Path, kvs, cfg); err != nil {
+				e.getOptions().Log().Error("watch channel unmarshal error", e.fields(err))
+				e.getOptions().ReportError(err)
 				continue
 			} else {
-				e.onLoaded(e.instance)
+				e.getOptions().LoadedDocument(cfg, doc)
 			}
 		}
 	}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"github.com/ti/noframe/config"
 	etcd "go.etcd.io/etcd/v3/client"
 	"go.etcd.io/etcd/v3/pkg/transport"
@@ -12,6 +11,7 @@ import (
 	"net/url"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,17 +19,24 @@ type etcdBackend struct {
 	url      *url.URL
 	client   etcd.Client
 	keyApis  etcd.KeysAPI
-	instance interface{}
+	options  config.Options
 	onLoaded config.OnLoaded
+	//modifiedIndex the ModifiedIndex of the node loaded or saved last
+	modifiedIndex uint64
+	mu            sync.Mutex
 }
 
 func init() {
-	config.AddBackend("etcdv2", &etcdBackend{})
+	config.AddBackend("etcdv2", New)
 }
 
-// New new instance
-func New() *etcdBackend {
-	return &etcdBackend{}
+// New new backend instance for a config
+func New(o config.Options) (config.Backend, error) {
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &etcdBackend{url: u}, nil
 }
 
 // LoadConfig gets the JSON from ETCD and unmarshals it to the config object
@@ -41,17 +48,12 @@ func (e *etcdBackend) LoadConfig(o config.Options) error {
 	var err error
 	var getResp *etcd.Response
 	var newEtcd bool
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(o.Context, 15*time.Second)
 	defer cancel()
-	if e.url == nil {
-		u, err := url.Parse(o.URL)
-		if err != nil {
-			return err
-		}
-		e.url = u
-		e.instance = o.DefaultConfig
-		e.onLoaded = o.OnLoaded
-	}
+	e.mu.Lock()
+	e.options = o
+	e.mu.Unlock()
+	e.onLoaded = o.OnLoaded
 
 	if e.keyApis == nil {
 		//first time to load config
@@ -60,59 +62,138 @@ func (e *etcdBackend) LoadConfig(o config.Options) error {
 			return err
 		}
 		e.keyApis = etcd.NewKeysAPI(e.client)
-		getResp, err = e.keyApis.Get(context.Background(), e.url.Path, nil)
+		getResp, err = e.keyApis.Get(ctx, e.url.Path, nil)
 		newEtcd = true
 	} else {
-		getResp, err = e.keyApis.Get(context.Background(), e.url.Path, nil)
+		getResp, err = e.keyApis.Get(ctx, e.url.Path, nil)
 		if err != nil && !etcd.IsKeyNotFound(err) {
 			e.client = nil
-			log.Warnf("etcd v2 get key error ", err, " try 1 time")
+			o.Log().Warn("etcd v2 get key error, try 1 time", e.fields(err))
 			e.client, err = newEtcdClient(e.url)
 			if err != nil {
 				return err
 			}
 			e.keyApis = etcd.NewKeysAPI(e.client)
-			getResp, err = e.keyApis.Get(context.Background(), e.url.Path, nil)
+			getResp, err = e.keyApis.Get(ctx, e.url.Path, nil)
 			newEtcd = true
 		}
 	}
 	if err != nil && !etcd.IsKeyNotFound(err) {
 		return fmt.Errorf("bad cluster endpoints, which are not etcd servers: %v", err)
 	}
+	cfg := o.NewConfig()
+	var doc interface{}
 	if etcd.IsKeyNotFound(err) {
-		cnfJson, _ := json.MarshalIndent(e.instance, "", "\t")
-		if _, err := e.keyApis.Set(ctx, e.url.Path, string(cnfJson), nil); err != nil {
+		cnfJson, _ := json.MarshalIndent(cfg, "", "\t")
+		setResp, err := e.keyApis.Set(ctx, e.url.Path, string(cnfJson), nil)
+		if err != nil {
 			return fmt.Errorf("key not found: %s, put error %s", e.url.Path, err)
 		}
+		e.setModifiedIndex(setResp.Node.ModifiedIndex)
 	} else {
-		if err := json.Unmarshal([]byte(getResp.Node.Value), e.instance); err != nil {
+		if err := json.Unmarshal([]byte(getResp.Node.Value), cfg); err != nil {
 			return err
 		}
+		json.Unmarshal([]byte(getResp.Node.Value), &doc)
+		e.setModifiedIndex(getResp.Node.ModifiedIndex)
 	}
 	watch := o.Watch && e.onLoaded != nil
 	if newEtcd && watch {
-		go e.watch()
+		go e.watch(o.Context)
+	}
+	return o.LoadedDocument(cfg, doc)
+}
+
+// SaveConfig write the config as JSON, it fails if the node is modified after it is loaded
+func (e *etcdBackend) SaveConfig(o config.Options, cfg interface{}) error {
+	cnfJson, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return fmt.Errorf("path %s marshal error %s", e.url.Path, err)
+	}
+	ctx, cancel := context.WithTimeout(o.Context, 15*time.Second)
+	defer cancel()
+	index := e.getModifiedIndex()
+	if o.Revision != 0 {
+		//the node may be reloaded after the config which is saved is loaded
+		index = uint64(o.Revision)
+	}
+	opts := &etcd.SetOptions{PrevIndex: index}
+	if index == 0 {
+		opts.PrevExist = etcd.PrevNoExist
+	}
+	resp, err := e.keyApis.Set(ctx, e.url.Path, string(cnfJson), opts)
+	if err != nil {
+		if etcdErr, ok := err.(etcd.Error); ok &&
+			(etcdErr.Code == etcd.ErrorCodeTestFailed || etcdErr.Code == etcd.ErrorCodeNodeExist) {
+			return &config.ErrConflict{Path: e.url.Path, Revision: int64(index)}
+		}
+		return fmt.Errorf("path %s save error %s", e.url.Path, err)
 	}
-	e.onLoaded(e.instance)
+	e.setModifiedIndex(resp.Node.ModifiedIndex)
 	return nil
 }
 
-func (e *etcdBackend) watch() {
-	ctx := context.TODO()
+// Revision the ModifiedIndex of the node loaded or saved last
+func (e *etcdBackend) Revision() int64 {
+	return int64(e.getModifiedIndex())
+}
+
+//fields the fields of log
+func (e *etcdBackend) fields(err error) config.Fields {
+	return config.Fields{"scheme": "etcdv2", "key": e.url.Path, "revision": e.Revision(), "error": err}
+}
+
+func (e *etcdBackend) setModifiedIndex(index uint64) {
+	e.mu.Lock()
+	e.modifiedIndex = index
+	e.mu.Unlock()
+}
+
+func (e *etcdBackend) getModifiedIndex() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.modifiedIndex
+}
+
+//getOptions return the options of the last load, which is replaced by every reload
+func (e *etcdBackend) getOptions() config.Options {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.options
+}
+
+func (e *etcdBackend) watch(ctx context.Context) {
 	wc := e.keyApis.Watcher(e.url.Path, &etcd.WatcherOptions{AfterIndex: 0, Recursive: true})
+	e.getOptions().ReportWatching(true)
+	defer e.getOptions().ReportWatching(false)
 	for {
 		rsp, err := wc.Next(ctx)
-		if err != nil && ctx.Err() != nil {
-			log.Error("etcd v2 watch error ", err)
-			return
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			e.getOptions().Log().Error("etcd v2 watch error", e.fields(err))
+			e.getOptions().ReportError(err)
+			e.getOptions().ReportWatching(false)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
 		}
+		e.getOptions().ReportWatching(true)
 		if rsp.Node.Dir {
 			continue
 		}
 		switch rsp.Action {
 		case "set", "update":
-			if err := json.Unmarshal([]byte(rsp.Node.Value), e.instance); err == nil {
-				e.onLoaded(e.instance)
+			cfg := e.getOptions().NewConfig()
+			if err := json.Unmarshal([]byte(rsp.Node.Value), cfg); err == nil {
+				var doc interface{}
+				json.Unmarshal([]byte(rsp.Node.Value), &doc)
+				e.setModifiedIndex(rsp.Node.ModifiedIndex)
+				e.getOptions().LoadedDocument(cfg, doc)
 			}
 		}
 	}
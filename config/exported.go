@@ -1,8 +1,20 @@
 package config
 
+import (
+	"context"
+	"flag"
+	"sync"
+)
+
 var (
 	// std is the name of the standard logger in stdlib `log`
 	std = New(map[string]interface{}{})
+	// backends the backend factories of every Config
+	backends = map[string]BackendFactory{
+		fileScheme: newFileBackend,
+		envScheme:  newEnvBackend,
+	}
+	backendsMu sync.RWMutex
 )
 
 //StandardConfig return instance of default config
@@ -15,10 +27,26 @@ func Init(opts ...Option) error {
 	return std.Init(opts...)
 }
 
+//InitContext init default config, it is stopped when ctx is done
+func InitContext(ctx context.Context, opts ...Option) error {
+	return std.InitContext(ctx, opts...)
+}
+
+//Close stop the polling and watching of default config
+func Close() error {
+	return std.Close()
+}
+
 //AddFieldListener bind some trigger when config is changed
 //if field is "", it add listen whole config
-func SetFieldListener(field string, onchange OnChange) {
-	std.SetFieldListener(field, onchange)
+func SetFieldListener(field string, onchange OnChange, opts ...ListenerOption) *Subscription {
+	return std.SetFieldListener(field, onchange, opts...)
+}
+
+//BindFlags register the flags of every field in default config to fs,
+//the default config should be set by Init(WithDefault(cfg)) before, the flags parsed later are applied at once
+func BindFlags(fs *flag.FlagSet, prefix string) error {
+	return std.BindFlags(fs, prefix)
 }
 
 //GetConfig set default config for a instance
@@ -26,7 +54,34 @@ func GetConfig() interface{} {
 	return std.GetConfig()
 }
 
-//AddBackend add backend
-func AddBackend(scheme string, backend Backend) {
-	std.AddBackend(scheme, backend)
+//AddBackend add backend factory for every Config
+func AddBackend(scheme string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[scheme] = factory
+}
+
+//SetPatternListener bind a trigger which is called with every changed path of default config which matches the pattern
+func SetPatternListener(pattern string, onMatch OnMatch, opts ...ListenerOption) *Subscription {
+	return std.SetPatternListener(pattern, onMatch, opts...)
+}
+
+//SetChangeListener bind a trigger which receives all the changes of default config once per reload
+func SetChangeListener(onChanges OnChanges) {
+	std.SetChangeListener(onChanges)
+}
+
+//SetErrorListener bind a trigger when a reloaded config is rejected
+func SetErrorListener(onError OnError) {
+	std.SetErrorListener(onError)
+}
+
+//Set set the value of field in path of default config, and save it to backend
+func Set(path string, value interface{}) error {
+	return std.Set(path, value)
+}
+
+//Update change the default config by update, and save it to backend
+func Update(update func(cfg interface{}) error) error {
+	return std.Update(update)
 }
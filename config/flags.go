@@ -0,0 +1,290 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//flagValue the flag of a field of config
+type flagValue struct {
+	//path the path of field, exp: Services[0].Hooks.Url
+	path    string
+	typ     reflect.Type
+	value   string
+	isSet   bool
+	isMerge bool
+	config  *Config
+}
+
+func (f *flagValue) String() string {
+	//flag package calls String of a zero flagValue to check the default value, and before it is bound
+	if f.config == nil {
+		return f.value
+	}
+	f.config.mu.Lock()
+	defer f.config.mu.Unlock()
+	return f.value
+}
+
+//Set check the value by the type of field, the k=v pairs of map are merged,
+//the config which is loaded already is published again with the flag
+func (f *flagValue) Set(s string) error {
+	if err := setString(reflect.New(f.typ).Elem(), s); err != nil {
+		return err
+	}
+	f.config.mu.Lock()
+	if f.isMerge && f.isSet && s != "" {
+		s = f.value + "," + s
+	}
+	f.value = s
+	f.isSet = true
+	f.config.mu.Unlock()
+	return f.config.reapplyFlags()
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.typ.Kind() == reflect.Bool
+}
+
+//BindFlags register the flags of every field in default config to fs,
+//exp: --addr, --services.0.name, --datasource k=v. The flags which are set
+//override the config loaded by backends, the flags parsed after Init are applied at once
+func (c *Config) BindFlags(fs *flag.FlagSet, prefix string) error {
+	c.mu.Lock()
+	v := reflect.Indirect(reflect.ValueOf(c.instance))
+	c.mu.Unlock()
+	if v.Kind() != reflect.Struct {
+		return errors.New("default config should be a struct")
+	}
+	//fs calls String of the flags when they are registered, so they are bound without lock
+	var flags []*flagValue
+	if err := bindFlags(fs, v, prefix, "", &flags); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range flags {
+		f.config = c
+	}
+	c.flags = append(c.flags, flags...)
+	return nil
+}
+
+//reapplyFlags publish the config loaded by Init again with the flags, it does nothing before Init or after Close
+func (c *Config) reapplyFlags() error {
+	c.mu.Lock()
+	pre, _ := c.current.Load().(*snapshot)
+	if pre == nil || c.cancel == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	var merged, written interface{}
+	var err error
+	if len(c.layers) == 0 {
+		merged, err = applyFlags(cloneInstance(c.instance), c.flags)
+	} else {
+		for _, l := range c.layers {
+			if l.cfg == nil {
+				//the flags are applied when the last layer is loaded
+				c.mu.Unlock()
+				return nil
+			}
+		}
+		merged, written, err = c.mergeWithFlags(c.layers[0].options.DefaultConfig, c.layers)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s, err := c.newSnapshot(merged)
+	if err != nil {
+		return err
+	}
+	s.written, s.revision, s.source = written, pre.revision, pre.source
+	return c.onReloaded(s)
+}
+
+func bindFlags(fs *flag.FlagSet, v reflect.Value, name, path string, flags *[]*flagValue) error {
+	t := v.Type()
+	switch {
+	case t.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			v = reflect.New(t.Elem())
+		}
+		return bindFlags(fs, v.Elem(), name, path, flags)
+	case t.Kind() == reflect.Struct && t != durationType:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if f.Anonymous {
+				if err := bindFlags(fs, v.Field(i), name, path, flags); err != nil {
+					return err
+				}
+				continue
+			}
+			names := fieldNames(f)
+			if err := bindFlags(fs, v.Field(i), joinFlag(name, names[len(names)-1]), joinPath(path, f.Name), flags); err != nil {
+				return err
+			}
+		}
+		return nil
+	case t.Kind() == reflect.Slice && !isScalar(t.Elem()):
+		for i := 0; i < v.Len(); i++ {
+			if err := bindFlags(fs, v.Index(i), joinFlag(name, strconv.Itoa(i)), path+"["+strconv.Itoa(i)+"]", flags); err != nil {
+				return err
+			}
+		}
+		return nil
+	case t.Kind() == reflect.Map && !(t.Key().Kind() == reflect.String && isScalar(t.Elem())):
+		if t.Key().Kind() != reflect.String {
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			if err := bindFlags(fs, v.MapIndex(k), joinFlag(name, k.String()), joinPath(path, k.String()), flags); err != nil {
+				return err
+			}
+		}
+		return nil
+	case t.Kind() == reflect.Interface || t.Kind() == reflect.Func || t.Kind() == reflect.Chan:
+		return nil
+	}
+	f := &flagValue{
+		path:    path,
+		typ:     t,
+		value:   flagString(v),
+		isMerge: t.Kind() == reflect.Map,
+	}
+	if fs.Lookup(name) != nil {
+		return fmt.Errorf("flag %s is redefined", name)
+	}
+	fs.Var(f, name, "config of "+path)
+	*flags = append(*flags, f)
+	return nil
+}
+
+//applyFlags set the flags which are set to a copy of cfg
+func applyFlags(cfg interface{}, flags []*flagValue) (interface{}, error) {
+	var set []*flagValue
+	for _, f := range flags {
+		if f.isSet {
+			set = append(set, f)
+		}
+	}
+	if len(set) == 0 {
+		return cfg, nil
+	}
+	cfg = cloneInstance(cfg)
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr {
+		return nil, errors.New("config should be a pointer to apply flags")
+	}
+	for _, f := range set {
+		err := setPath(v.Elem(), compile(f.path), func(field reflect.Value) error {
+			return setString(field, f.value)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("flag of %s error for %s", f.path, err)
+		}
+	}
+	return cfg, nil
+}
+
+//setPath call set with the field of paths, the slices are grown and the maps are set back
+func setPath(v reflect.Value, paths []string, set func(reflect.Value) error) error {
+	if len(paths) == 0 {
+		return set(v)
+	}
+	key := paths[0]
+	switch k := v.Kind(); k {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setPath(v.Elem(), paths, set)
+	case reflect.Struct:
+		f := v.FieldByName(key)
+		if !f.IsValid() {
+			return fmt.Errorf("%s has no field %s", v.Type(), key)
+		}
+		return setPath(f, paths[1:], set)
+	case reflect.Slice:
+		n, err := strconv.Atoi(key)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s is not a number", key)
+		}
+		if n >= v.Len() {
+			grown := reflect.MakeSlice(v.Type(), n+1, n+1)
+			reflect.Copy(grown, v)
+			v.Set(grown)
+		}
+		return setPath(v.Index(n), paths[1:], set)
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		mapKey := reflect.ValueOf(key).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if old := v.MapIndex(mapKey); old.IsValid() {
+			elem.Set(old)
+		}
+		if err := setPath(elem, paths[1:], set); err != nil {
+			return err
+		}
+		v.SetMapIndex(mapKey, elem)
+		return nil
+	default:
+		return fmt.Errorf("%s is not supported", k)
+	}
+}
+
+//flagString the default value of flag in the format of setString
+func flagString(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return ""
+		}
+		return flagString(v.Elem())
+	case reflect.Slice:
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = flagString(v.Index(i))
+		}
+		return strings.Join(parts, ",")
+	case reflect.Map:
+		var parts []string
+		for _, k := range v.MapKeys() {
+			parts = append(parts, k.String()+"="+flagString(v.MapIndex(k)))
+		}
+		sort.Strings(parts)
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func joinFlag(name, child string) string {
+	if name == "" {
+		return strings.ToLower(child)
+	}
+	return name + "." + strings.ToLower(child)
+}
+
+func joinPath(path, child string) string {
+	if path == "" {
+		return child
+	}
+	return path + "." + child
+}
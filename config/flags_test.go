@@ -0,0 +1,77 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBindFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"Addr":":8080","LogLevel":"info"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&envKV{TestKV: testKV, Timeout: time.Second})
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	if err := c.BindFlags(fs, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"addr", "services.0.name", "services.1.hooks.url", "datasource", "test_config", "timeout", "ports"} {
+		if fs.Lookup(name) == nil {
+			t.Fatalf("flag %s is not registered", name)
+		}
+	}
+	err := fs.Parse([]string{
+		"--addr", ":7070",
+		"--services.1.hooks.url", "http://127.0.0.1:9091/hooks",
+		"--datasource", "cache=redis://flag",
+		"--datasource", "extra=mysql://flag",
+		"--timeout", "5s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"--timeout", "5"}); err == nil {
+		t.Fatal("expect error for invalid duration")
+	}
+	if err := c.Init(URL("file://"+path), Watch(false)); err != nil {
+		t.Fatal(err)
+	}
+	current := c.GetConfig().(*envKV)
+	if current.Addr != ":7070" || current.LogLevel != "info" || current.Timeout != 5*time.Second {
+		t.Fatalf("config %v does not match expect", current)
+	}
+	if current.Services[1].Hooks.Url != "http://127.0.0.1:9091/hooks" || current.Services[1].Name != "userinfo" {
+		t.Fatalf("service %v does not match expect", current.Services[1])
+	}
+	if current.DataSource["cache"] != "redis://flag" || current.DataSource["extra"] != "mysql://flag" || current.DataSource["sql"] != testKV.DataSource["sql"] {
+		t.Fatalf("data source %v does not match expect", current.DataSource)
+	}
+}
+
+func TestBindFlagsAfterInit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"Addr":":8080","LogLevel":"info"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&envKV{})
+	if err := c.Init(URL("file://"+path), Watch(false), WithDefault(&envKV{TestKV: testKV, Timeout: time.Second})); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	if err := c.BindFlags(fs, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"--addr", ":7070", "--timeout", "5s"}); err != nil {
+		t.Fatal(err)
+	}
+	current := c.GetConfig().(*envKV)
+	if current.Addr != ":7070" || current.LogLevel != "info" || current.Timeout != 5*time.Second {
+		t.Fatalf("config %v does not match expect", current)
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+//defaultHistorySize the number of applied configs kept when HistorySize is not set
+const defaultHistorySize = 16
+
+//HistoryEntry a config which is applied
+type HistoryEntry struct {
+	//Version the sequence of applied configs of a Config, it starts from 1
+	Version int
+	Time    time.Time
+	//Source the scheme of backend which loads the config, exp: file, etcd
+	Source string
+	//Revision the revision of config in backend
+	Revision int64
+	//Changes the changes from the previous config
+	Changes []Change
+	//cfg the config of the writable source, the other sources are not written back by Rollback
+	cfg interface{}
+}
+
+//History get the configs which are applied in order, the oldest ones are dropped
+//when the size of history is exceeded
+func (c *Config) History() []HistoryEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	history := make([]HistoryEntry, len(c.history))
+	for i, entry := range c.history {
+		history[i] = *entry
+	}
+	return history
+}
+
+//Rollback write the config of the writable source at version in history back to its backend by Update,
+//it is applied as a new version when it is saved, the other sources such as env and flags are kept as they are
+func (c *Config) Rollback(version int) error {
+	c.mu.Lock()
+	var target *HistoryEntry
+	for _, entry := range c.history {
+		if entry.Version == version {
+			target = entry
+		}
+	}
+	c.mu.Unlock()
+	if target == nil {
+		return fmt.Errorf("version %d is not in history", version)
+	}
+	if target.cfg == nil {
+		return fmt.Errorf("version %d has no config of writable source", version)
+	}
+	return c.Update(func(cfg interface{}) error {
+		old := reflect.Indirect(reflect.ValueOf(cloneInstance(target.cfg)))
+		v := reflect.ValueOf(cfg).Elem()
+		if old.Type() != v.Type() {
+			return errors.New("config of history does not match the config of backend")
+		}
+		v.Set(old)
+		return nil
+	})
+}
+
+//record add the snapshot which is applied to history, it should be called with lock
+func (c *Config) record(s *snapshot, changes []Change) {
+	c.version++
+	c.history = append(c.history, &HistoryEntry{
+		Version:  c.version,
+		Time:     time.Now(),
+		Source:   s.source,
+		Revision: s.revision,
+		Changes:  changes,
+		cfg:      s.written,
+	})
+	size := c.historySize
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	if len(c.history) > size {
+		c.history = append(c.history[:0:0], c.history[len(c.history)-size:]...)
+	}
+}
@@ -6,8 +6,36 @@ type Backend interface {
 	LoadConfig(options Options) error
 }
 
+// Writer defines a backend which can save the config, implement this interface
+// to support Config.Set and Config.Update
+type Writer interface {
+	SaveConfig(options Options, cfg interface{}) error
+}
+
+// Revisioner defines a backend which knows the revision of the config it loaded or saved last,
+// the revision is exposed by Config.Revision
+type Revisioner interface {
+	Revision() int64
+}
+
+//BackendFactory new a backend for a Config, every Config has its own backend
+//so the clients and states of backend are never shared
+type BackendFactory func(options Options) (Backend, error)
+
 //OnChange Trigger On config change function
 type OnChange func(pre, current interface{})
 
-//OnLoaded Trigger On config is loaded
-type OnLoaded func(cfg interface{})
+//OnMatch Trigger On the path which matches a pattern is changed
+type OnMatch func(path string, pre, current interface{})
+
+//OnChanges Trigger On config is changed with all the changed paths
+type OnChanges func(changes []Change)
+
+//OnLoaded Trigger On config is loaded, it returns error if the config is rejected
+type OnLoaded func(cfg interface{}) error
+
+//OnError Trigger On reloaded config is rejected
+type OnError func(err error)
+
+//OnWatching Trigger On the watching of backend is started, broken or stopped
+type OnWatching func(watching bool)
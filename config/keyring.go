@@ -0,0 +1,238 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//encryptedPrefix the prefix of the values encrypted by Keyring, exp: enc:v1:k1:base64(nonce|ciphertext)
+const encryptedPrefix = "enc:v1:"
+
+//KeySize the size of the keys of Keyring, they are the keys of AES-256-GCM
+const KeySize = 32
+
+//Keyring the keys which encrypt and decrypt the values of config, the values are encrypted by the primary key,
+//and decrypted by the key whose id is in the value, so the old keys can be kept for rotation.
+//The keyring file is json, exp: {"primary":"k2","keys":{"k1":"base64 key","k2":"base64 key"}}
+type Keyring struct {
+	mu      sync.RWMutex
+	primary string
+	keys    map[string][]byte
+}
+
+type keyringFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+//NewKeyring new an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+//LoadKeyring load the keyring from the local file
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyringFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("keyring %s error for %s", path, err)
+	}
+	k := NewKeyring()
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s of keyring %s error for %s", id, path, err)
+		}
+		if err := k.AddKey(id, key, false); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := k.keys[f.Primary]; !ok {
+		return nil, fmt.Errorf("primary key %q of keyring %s is not found", f.Primary, path)
+	}
+	k.primary = f.Primary
+	return k, nil
+}
+
+//Save write the keyring to the local file which can only be read by the owner
+func (k *Keyring) Save(path string) error {
+	k.mu.RLock()
+	f := keyringFile{Primary: k.primary, Keys: make(map[string]string, len(k.keys))}
+	for id, key := range k.keys {
+		f.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	k.mu.RUnlock()
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+//AddKey add a key of KeySize to the keyring, the values are encrypted by it if it is primary
+func (k *Keyring) AddKey(id string, key []byte, primary bool) error {
+	if id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("key id %q should not be empty or contain ':'", id)
+	}
+	if len(key) != KeySize {
+		return fmt.Errorf("key %s should be %d bytes", id, KeySize)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte{}, key...)
+	if primary || k.primary == "" {
+		k.primary = id
+	}
+	return nil
+}
+
+//GenerateKey add a random key to the keyring, the values are encrypted by it if it is primary
+func (k *Keyring) GenerateKey(id string, primary bool) error {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	return k.AddKey(id, key, primary)
+}
+
+//Primary the id of the key which encrypts the values
+func (k *Keyring) Primary() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+//IDs the sorted ids of the keys in the keyring
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+//Encrypt encrypt the value by the primary key, exp: enc:v1:k1:base64(nonce|ciphertext)
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	k.mu.RLock()
+	id, key := k.primary, k.keys[k.primary]
+	k.mu.RUnlock()
+	if key == nil {
+		return "", errors.New("keyring has no primary key")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(encryptedPrefix+id))
+	return encryptedPrefix + id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+//Decrypt decrypt the value encrypted by any key of the keyring
+func (k *Keyring) Decrypt(ciphertext string) (string, error) {
+	if !IsEncrypted(ciphertext) {
+		return "", errors.New("value is not encrypted")
+	}
+	envelope := strings.SplitN(strings.TrimPrefix(ciphertext, encryptedPrefix), ":", 2)
+	if len(envelope) != 2 {
+		return "", errors.New("encrypted value has no key id")
+	}
+	id := envelope[0]
+	k.mu.RLock()
+	key := k.keys[id]
+	k.mu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("key %s is not in keyring", id)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(envelope[1])
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(encryptedPrefix+id))
+	if err != nil {
+		return "", fmt.Errorf("value can not be decrypted by key %s", id)
+	}
+	return string(plaintext), nil
+}
+
+//Resolve decrypt the encrypted values as a SecretResolver
+func (k *Keyring) Resolve(ref string) (string, bool, error) {
+	if !IsEncrypted(ref) {
+		return "", false, nil
+	}
+	plaintext, err := k.Decrypt(ref)
+	return plaintext, true, err
+}
+
+//IsEncrypted the value is encrypted by Keyring
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+//WithKeyring decrypt the encrypted values of config by the keyring, the plaintexts of the fields
+//tagged by `config:"name,encrypted"` are encrypted by it when the config is saved by Update
+func WithKeyring(keyring *Keyring) Option {
+	return func(o *Options) {
+		o.Keyring = keyring
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//isEncryptedField the field is tagged by `config:"name,encrypted"`
+func isEncryptedField(f reflect.StructField) bool {
+	t := f.Tag.Get(tagName)
+	if i := strings.Index(t, ","); i >= 0 {
+		for _, opt := range strings.Split(t[i+1:], ",") {
+			if opt == "encrypted" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//encryptFields encrypt the plaintexts of the fields tagged by `config:"name,encrypted"` in cfg
+func encryptFields(cfg interface{}, keyring *Keyring) error {
+	return walkFields(reflect.ValueOf(cfg), "", false, func(path, s string, encrypted bool) (string, error) {
+		if !encrypted || s == "" || IsEncrypted(s) {
+			return s, nil
+		}
+		if keyring == nil {
+			return "", fmt.Errorf("field %s is encrypted, but the config has no keyring", path)
+		}
+		return keyring.Encrypt(s)
+	})
+}
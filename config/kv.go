@@ -235,6 +235,84 @@ func Marshal(key string, target interface{}) (kvs []*KV, err error) {
 	return
 }
 
+//StoredKV the value of a key loaded from backend with its revision, exp: ModRevision of etcd, ModifyIndex of consul
+type StoredKV struct {
+	Value    string
+	Revision int64
+}
+
+//KVChange the change of a key which is saved by backend, the key is put if Delete is false,
+//Revision is the revision the key is loaded at, 0 means the key should not exist
+type KVChange struct {
+	Key      string
+	Value    string
+	Delete   bool
+	Revision int64
+}
+
+//StoredRevision the max revision of the stored keys
+func StoredRevision(stored map[string]StoredKV) int64 {
+	var revision int64
+	for _, kv := range stored {
+		if kv.Revision > revision {
+			revision = kv.Revision
+		}
+	}
+	return revision
+}
+
+//DiffKVs the changes from the stored keys to kvs marshalled by Marshal, the keys which are not changed are skipped,
+//the stored items of map or slice under dirs of GetPrefixKeys are deleted if they are not in kvs
+func DiffKVs(stored map[string]StoredKV, kvs []*KV, dirs []string) []KVChange {
+	var changes []KVChange
+	keys := make(map[string]bool, len(kvs))
+	for _, kv := range kvs {
+		keys[kv.Key] = true
+		old, ok := stored[kv.Key]
+		if ok && old.Value == kv.Value {
+			continue
+		}
+		changes = append(changes, KVChange{Key: kv.Key, Value: kv.Value, Revision: old.Revision})
+	}
+	var deleted []string
+	for key := range stored {
+		if !keys[key] && inDirs(key, dirs) {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	for _, key := range deleted {
+		changes = append(changes, KVChange{Key: key, Delete: true, Revision: stored[key].Revision})
+	}
+	return changes
+}
+
+//SavedKVs the stored keys after the changes are saved, revision get the revision a put key is saved at
+func SavedKVs(stored map[string]StoredKV, changes []KVChange, revision func(key string) int64) map[string]StoredKV {
+	saved := make(map[string]StoredKV, len(stored))
+	for key, kv := range stored {
+		saved[key] = kv
+	}
+	for _, change := range changes {
+		if change.Delete {
+			delete(saved, change.Key)
+			continue
+		}
+		saved[change.Key] = StoredKV{Value: change.Value, Revision: revision(change.Key)}
+	}
+	return saved
+}
+
+//inDirs the key is an item of the dir keys which end with "/"
+func inDirs(key string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasSuffix(dir, "/") && strings.HasPrefix(key, dir) && key != dir {
+			return true
+		}
+	}
+	return false
+}
+
 func uniMarshal(fKey string, fKind reflect.Kind, data interface{}) (childKvs []*KV, err error) {
 	if !strings.HasSuffix(fKey, "/") {
 		childKvs, err = simpleKVMarshal(fKey, data)
@@ -332,6 +410,26 @@ func Unmarshal(key string, kvs []*KV, target interface{}) (err error) {
 	return
 }
 
+//UnmarshalDocument unmarshal kv array to target like Unmarshal, and get the document of the keys in kv array,
+//the backends publish it by Options.LoadedDocument, the document is nil if target is not a struct
+func UnmarshalDocument(key string, kvs []*KV, target interface{}) (interface{}, error) {
+	if err := Unmarshal(key, kvs, target); err != nil {
+		return nil, err
+	}
+	if getReflectValue(target).Kind() != reflect.Struct {
+		return nil, nil
+	}
+	js, err := unmarshalKVStructToJson(key, target, kvs)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(js), &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
 func unmarshalKVStructToJson(key string, target interface{}, kvs []*KV) (js string, err error) {
 	rootKey := key
 	if !strings.HasSuffix(rootKey, "/") {
@@ -7,7 +7,7 @@ import (
 )
 
 func TestGetKeysKind(t *testing.T) {
-	keysKind, err := GetKeysKind("/dir/test", &testKV)
+	keysKind, err := getKeysKind("/dir/test", &testKV)
 	if err != nil {
 		t.Fail()
 	}
@@ -138,3 +138,44 @@ type Hooks struct {
 	Url string
 	Key string
 }
+
+func TestDiffKVs(t *testing.T) {
+	stored := map[string]StoredKV{
+		"/app":             {Value: `{"Addr":":8080"}`, Revision: 3},
+		"/app/items/a":     {Value: "1", Revision: 4},
+		"/app/items/b":     {Value: "2", Revision: 5},
+		"/app/items/c":     {Value: "3", Revision: 6},
+		"/other/unrelated": {Value: "x", Revision: 7},
+	}
+	kvs := []*KV{
+		{Key: "/app", Value: `{"Addr":":8080"}`},
+		{Key: "/app/items/a", Value: "10"},
+		{Key: "/app/items/d", Value: "4"},
+	}
+	changes := DiffKVs(stored, kvs, []string{"/app", "/app/items/"})
+	expect := []KVChange{
+		{Key: "/app/items/a", Value: "10", Revision: 4},
+		{Key: "/app/items/d", Value: "4"},
+		{Key: "/app/items/b", Delete: true, Revision: 5},
+		{Key: "/app/items/c", Delete: true, Revision: 6},
+	}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("changes %+v does not match expect %+v", changes, expect)
+	}
+	if revision := StoredRevision(stored); revision != 7 {
+		t.Fatalf("revision %d does not match expect 7", revision)
+	}
+	saved := SavedKVs(stored, changes, func(string) int64 { return 8 })
+	expectSaved := map[string]StoredKV{
+		"/app":             {Value: `{"Addr":":8080"}`, Revision: 3},
+		"/app/items/a":     {Value: "10", Revision: 8},
+		"/app/items/d":     {Value: "4", Revision: 8},
+		"/other/unrelated": {Value: "x", Revision: 7},
+	}
+	if !reflect.DeepEqual(saved, expectSaved) {
+		t.Fatalf("saved %+v does not match expect %+v", saved, expectSaved)
+	}
+	if len(DiffKVs(saved, kvs, []string{"/app", "/app/items/"})) != 0 {
+		t.Fatal("expect no changes for the saved kvs")
+	}
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+//listener a listener of field or pattern, the listeners are called in the order of registration
+type listener struct {
+	path string
+	//pattern the compiled path if the path has wildcards
+	pattern  []string
+	onChange OnChange
+	onMatch  OnMatch
+	//timeout the max time to wait for the listener, it is not limited if it is 0
+	timeout time.Duration
+}
+
+//ListenerOption the option of a listener
+type ListenerOption func(*listener)
+
+//ListenerTimeout stop waiting for the listener after timeout, the timeout is reported to the error listener,
+//the listener is still running in its own goroutine
+func ListenerTimeout(timeout time.Duration) ListenerOption {
+	return func(l *listener) {
+		l.timeout = timeout
+	}
+}
+
+//Subscription the handle of a listener, the listener is removed by Cancel
+type Subscription struct {
+	c *Config
+	l *listener
+}
+
+//Cancel remove the listener, it is safe to be called more than once
+func (s *Subscription) Cancel() {
+	if s == nil || s.l == nil {
+		return
+	}
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	for i, l := range s.c.listeners {
+		if l == s.l {
+			s.c.listeners = append(s.c.listeners[:i:i], s.c.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+//SetFieldListener bind some trigger when config is changed, the field can be a pattern of SetPatternListener,
+//onChange is called for every path which matches it. The listeners of a field are called in the order
+//of registration outside of the lock, the panics of them are reported to the error listener,
+//all the listeners of field are removed if onChange is nil
+func (c *Config) SetFieldListener(field string, onChange OnChange, opts ...ListenerOption) *Subscription {
+	if onChange == nil {
+		c.removeListeners(field)
+		return nil
+	}
+	l := &listener{path: field, onChange: onChange}
+	if isPattern(field) {
+		l.pattern = compile(field)
+		l.onMatch = func(path string, pre, current interface{}) {
+			onChange(pre, current)
+		}
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	return c.addListener(l)
+}
+
+//SetPatternListener bind a trigger which is called with every changed path which matches the pattern,
+//* matches a field, an item of slice or a key of map, ** matches every path under it,
+//exp: Services[*].Hooks, DataSource.*, Services.**
+func (c *Config) SetPatternListener(pattern string, onMatch OnMatch, opts ...ListenerOption) *Subscription {
+	if onMatch == nil {
+		c.removeListeners(pattern)
+		return nil
+	}
+	l := &listener{path: pattern, pattern: compile(pattern), onMatch: onMatch}
+	for _, o := range opts {
+		o(l)
+	}
+	return c.addListener(l)
+}
+
+func (c *Config) addListener(l *listener) *Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, l)
+	return &Subscription{c: c, l: l}
+}
+
+func (c *Config) removeListeners(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	listeners := c.listeners[:0:0]
+	for _, l := range c.listeners {
+		if l.path != path {
+			listeners = append(listeners, l)
+		}
+	}
+	c.listeners = listeners
+}
+
+//notify call the listener with the changes of event
+func (l *listener) notify(c *Config, ev *event) {
+	preInstance, newConfig := ev.preInstance, ev.newConfig
+	if l.pattern != nil {
+		for _, path := range matchPaths(l.pattern, ev.pre, ev.cfg, ev.changes) {
+			//the value of path which is not exist is nil
+			oldValue, _ := getFieldValue(preInstance, path)
+			newValue, _ := getFieldValue(newConfig, path)
+			if !reflect.DeepEqual(oldValue, newValue) {
+				c.invoke(path, l.timeout, func() {
+					l.onMatch(path, oldValue, newValue)
+				})
+			}
+		}
+		return
+	}
+	hasPreInstance := preInstance != nil
+	var oldValue interface{}
+	if hasPreInstance {
+		oldValue, _ = getFieldValue(preInstance, l.path)
+	}
+	newValue, err := getFieldValue(newConfig, l.path)
+	if err != nil {
+		c.log().Warn("can not get config by field", Fields{"path": l.path, "error": err})
+		return
+	}
+	if newValue == nil {
+		if oldValue != nil {
+			tx := reflect.Indirect(reflect.ValueOf(oldValue)).Type()
+			newValue = reflect.New(tx).Interface()
+			if reflect.ValueOf(oldValue).Kind() != reflect.Ptr {
+				newValue = reflect.Indirect(reflect.ValueOf(newValue)).Interface()
+			}
+			c.invoke(l.path, l.timeout, func() {
+				l.onChange(oldValue, newValue)
+			})
+		}
+	} else {
+		if !hasPreInstance || !reflect.DeepEqual(oldValue, newValue) {
+			if oldValue == nil {
+				tx := reflect.Indirect(reflect.ValueOf(newValue)).Type()
+				oldValue = reflect.New(tx).Interface()
+				if reflect.ValueOf(newValue).Kind() != reflect.Ptr {
+					oldValue = reflect.Indirect(reflect.ValueOf(oldValue)).Interface()
+				}
+			}
+			c.invoke(l.path, l.timeout, func() {
+				l.onChange(oldValue, newValue)
+			})
+		}
+	}
+}
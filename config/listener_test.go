@@ -0,0 +1,185 @@
+package config
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscription(t *testing.T) {
+	c := New(&TestKV{})
+	var called []string
+	first := c.SetFieldListener("LogLevel", func(pre, current interface{}) {
+		called = append(called, "first:"+current.(string))
+	})
+	c.SetFieldListener("LogLevel", func(pre, current interface{}) {
+		called = append(called, "second:"+current.(string))
+	})
+	initCas(t, c, nil)
+	first.Cancel()
+	first.Cancel()
+	if err := c.Set("LogLevel", "info"); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"first:debug", "second:debug", "second:info"}
+	if !reflect.DeepEqual(called, expect) {
+		t.Fatalf("called %v does not match expect %v", called, expect)
+	}
+	c.SetFieldListener("LogLevel", nil)
+	if err := c.Set("LogLevel", "warn"); err != nil {
+		t.Fatal(err)
+	}
+	if len(called) != len(expect) {
+		t.Fatalf("listeners should be removed, called %v", called)
+	}
+}
+
+func TestListenerIsolation(t *testing.T) {
+	c := New(&TestKV{})
+	var mu sync.Mutex
+	var errs []string
+	c.SetErrorListener(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err.Error())
+	})
+	var levels []string
+	c.SetFieldListener("LogLevel", func(pre, current interface{}) {
+		panic("listener panics")
+	})
+	c.SetFieldListener("LogLevel", func(pre, current interface{}) {
+		//the config can be read in listener
+		mu.Lock()
+		defer mu.Unlock()
+		levels = append(levels, c.GetConfig().(*TestKV).LogLevel)
+	})
+	block := make(chan struct{})
+	defer close(block)
+	c.SetFieldListener("Addr", func(pre, current interface{}) {
+		<-block
+	}, ListenerTimeout(10*time.Millisecond))
+	initCas(t, c, nil, WithAsyncListeners(4))
+	defer c.Close()
+	if err := c.Set("LogLevel", "info"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(levels) == 2 && len(errs) == 3
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(levels, []string{"info", "info"}) && !reflect.DeepEqual(levels, []string{"debug", "info"}) {
+		t.Fatalf("levels %v does not match expect", levels)
+	}
+	joined := strings.Join(errs, ";")
+	if strings.Count(joined, "panics:") != 2 || strings.Count(joined, "timeout") != 1 {
+		t.Fatalf("errors %v does not match expect", errs)
+	}
+}
+
+func TestListenerUpdate(t *testing.T) {
+	t.Run("sync", func(t *testing.T) {
+		testListenerUpdate(t)
+	})
+	t.Run("async", func(t *testing.T) {
+		testListenerUpdate(t, WithAsyncListeners(1))
+	})
+}
+
+func testListenerUpdate(t *testing.T, opts ...Option) {
+	c := New(&TestKV{})
+	var mu sync.Mutex
+	var addrs []string
+	c.SetFieldListener("Addr", func(pre, current interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		addrs = append(addrs, current.(string))
+	})
+	c.SetFieldListener("LogLevel", func(pre, current interface{}) {
+		if current != "info" {
+			return
+		}
+		//the updates are called on the dispatching goroutine, the queue of size 1 is full after the first one if it is async
+		for _, addr := range []string{":8081", ":8082"} {
+			if err := c.Set("Addr", addr); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	initCas(t, c, nil, opts...)
+	defer c.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Set("LogLevel", "info")
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set is blocked by the listener which calls Set")
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(addrs) == 3
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if expect := []string{testKV.Addr, ":8081", ":8082"}; !reflect.DeepEqual(addrs, expect) {
+		t.Fatalf("addrs %v does not match expect %v", addrs, expect)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := New(&TestKV{})
+	initCas(t, c, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.Watch(ctx, "LogLevel")
+	for _, level := range []string{"info", "warn"} {
+		if err := c.Set("LogLevel", level); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the changes are coalesced
+	expect := Change{Path: "LogLevel", Old: "info", New: "warn", Kind: ChangeModified}
+	if change := <-ch; !reflect.DeepEqual(change, expect) {
+		t.Fatalf("change %+v does not match expect %+v", change, expect)
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed when ctx is done")
+	}
+	//the changes of different paths in a reload are all kept
+	ch = c.Watch(context.Background(), "Services[*].Name")
+	err := c.Update(func(cfg interface{}) error {
+		kv := cfg.(*TestKV)
+		kv.Services[0].Name, kv.Services[1].Name = "renamedA", "renamedB"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := map[string]interface{}{}
+	for len(renamed) < 2 {
+		select {
+		case change := <-ch:
+			renamed[change.Path] = change.New
+		case <-time.After(5 * time.Second):
+			t.Fatalf("changes %v are not received", renamed)
+		}
+	}
+	if expect := map[string]interface{}{"Services[0].Name": "renamedA", "Services[1].Name": "renamedB"}; !reflect.DeepEqual(renamed, expect) {
+		t.Fatalf("changes %v does not match expect %v", renamed, expect)
+	}
+	c.Close()
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed when config is closed")
+	}
+}
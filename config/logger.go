@@ -0,0 +1,58 @@
+package config
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+//Fields the structured fields of log, exp: scheme, key, revision and error
+type Fields map[string]interface{}
+
+//Logger the logger of config and backends, implement it to route the logs to zap, slog ...
+type Logger interface {
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, fields Fields)
+}
+
+//DiscardLogger the logger which discards all the logs, exp: WithLogger(DiscardLogger) in tests
+var DiscardLogger Logger = discardLogger{}
+
+//defaultLogger the logger of logrus which is used when no logger is set
+var defaultLogger Logger = logrusLogger{}
+
+//WithLogger set the logger of config and its backends
+func WithLogger(logger Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+type logrusLogger struct{}
+
+func (logrusLogger) Info(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Info(msg)
+}
+
+func (logrusLogger) Warn(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Warn(msg)
+}
+
+func (logrusLogger) Error(msg string, fields Fields) {
+	log.WithFields(log.Fields(fields)).Error(msg)
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Info(msg string, fields Fields)  {}
+func (discardLogger) Warn(msg string, fields Fields)  {}
+func (discardLogger) Error(msg string, fields Fields) {}
+
+//withError a copy of fields with the error
+func withError(fields Fields, err error) Fields {
+	dist := make(Fields, len(fields)+1)
+	for k, v := range fields {
+		dist[k] = v
+	}
+	dist["error"] = err
+	return dist
+}
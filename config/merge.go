@@ -0,0 +1,279 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//layer the config loaded by one of the sources
+type layer struct {
+	options Options
+	backend Backend
+	//cfg the last config loaded by the backend, it is nil before the first load
+	cfg interface{}
+	//doc the document which cfg is decoded from, the keys in it override the former layers,
+	//the values which differ from the default config override the former layers if it is nil
+	doc interface{}
+	//revision the revision of cfg in backend
+	revision int64
+	//watching the backend is watching the changes, it is reported by backend
+	watching bool
+	//loadedAt the time of last load, err is the last error of loading which happens at errAt
+	loadedAt time.Time
+	err      error
+	errAt    time.Time
+}
+
+//itemsDocument the document of the items of slice which are set by index, exp: the env SERVICES_0_NAME,
+//the other items of the slice are taken from the former layers
+type itemsDocument map[int]interface{}
+
+//mergeLayers deep merge the configs of layers over the default config in order, the keys in the document
+//of a layer are taken from it, so a latter layer can set a value back to the default one. Only the values
+//which differ from the default config are taken from a layer without document.
+//It returns the document of the merged layers too
+func mergeLayers(defaultConfig interface{}, layers []*layer) (interface{}, interface{}, error) {
+	if len(layers) == 1 {
+		return layers[0].cfg, layers[0].doc, nil
+	}
+	defaults, err := toMap(defaultConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	var merged interface{} = cloneMap(defaults)
+	doc := make(map[string]interface{})
+	for _, l := range layers {
+		if l.cfg == nil {
+			continue
+		}
+		m, err := toMap(l.cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		overlay, ok := overlayOf(m, defaults)
+		if l.doc != nil {
+			overlay, ok = documentOverlay(reflect.TypeOf(l.cfg), m, l.doc)
+		}
+		if ok {
+			merged = deepMerge(merged, overlay)
+			deepMerge(doc, cloneMap(overlay))
+		}
+	}
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := cloneInstance(defaultConfig)
+	if reflect.ValueOf(cfg).Kind() != reflect.Ptr {
+		ptr := reflect.New(reflect.TypeOf(cfg))
+		if err := json.Unmarshal(b, ptr.Interface()); err != nil {
+			return nil, nil, err
+		}
+		return ptr.Elem().Interface(), doc, nil
+	}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, nil, err
+	}
+	return cfg, doc, nil
+}
+
+//documentOverlay get the part of src whose keys are present in the document doc, t is the type of config,
+//src is the generic value of json of it, the keys of doc are matched to the fields case insensitive
+func documentOverlay(t reflect.Type, src, doc interface{}) (interface{}, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if items, ok := doc.(itemsDocument); ok && t.Kind() == reflect.Slice {
+		srcItems, _ := src.([]interface{})
+		overlay := make(itemsDocument, len(items))
+		for i, v := range items {
+			if i >= len(srcItems) {
+				continue
+			}
+			if o, ok := documentOverlay(t.Elem(), srcItems[i], v); ok {
+				overlay[i] = o
+			}
+		}
+		return overlay, len(overlay) > 0
+	}
+	docMap, ok := toStringMap(doc)
+	if !ok {
+		return src, true
+	}
+	srcMap, _ := src.(map[string]interface{})
+	overlay := make(map[string]interface{})
+	switch {
+	case t.Kind() == reflect.Struct && hasExportedField(t):
+		documentFields(t, srcMap, docMap, overlay)
+	case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+		for k, v := range docMap {
+			if value, ok := srcMap[k]; ok {
+				if o, ok := documentOverlay(t.Elem(), value, v); ok {
+					overlay[k] = o
+				}
+			}
+		}
+	default:
+		return src, true
+	}
+	return overlay, len(overlay) > 0
+}
+
+//documentFields add the fields of struct t which are present in doc to overlay by the names of json
+func documentFields(t reflect.Type, src, doc, overlay map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || jsonName == "-" {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && jsonName == "" && ft.Kind() == reflect.Struct {
+			//the fields of embedded struct are promoted
+			documentFields(ft, src, doc, overlay)
+			continue
+		}
+		if jsonName == "" {
+			jsonName = f.Name
+		}
+		docValue, ok := documentField(doc, f)
+		if !ok {
+			continue
+		}
+		value, ok := src[jsonName]
+		if !ok {
+			//the value is omitted by omitempty
+			value, _ = toMap(reflect.Zero(f.Type).Interface())
+		}
+		if o, ok := documentOverlay(f.Type, value, docValue); ok {
+			overlay[jsonName] = o
+		}
+	}
+}
+
+//documentField the value of field in the document, the names of field in json, yaml, toml and config are matched
+func documentField(doc map[string]interface{}, f reflect.StructField) (interface{}, bool) {
+	names := fieldNames(f)
+	for _, tag := range []string{"yaml", "toml"} {
+		if name := strings.Split(f.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	for k, v := range doc {
+		for _, name := range names {
+			if strings.EqualFold(k, name) {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+//toStringMap convert the map of document to the map of string keys, exp: the maps decoded by yaml
+func toStringMap(doc interface{}) (map[string]interface{}, bool) {
+	switch m := doc.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		dist := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			dist[fmt.Sprint(k)] = v
+		}
+		return dist, true
+	}
+	return nil, false
+}
+
+//toMap convert the config to the generic value of json
+func toMap(src interface{}) (interface{}, error) {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return nil, err
+	}
+	var dist interface{}
+	if err := json.Unmarshal(b, &dist); err != nil {
+		return nil, err
+	}
+	return dist, nil
+}
+
+//overlayOf get the part of src which is not the same as base
+func overlayOf(src, base interface{}) (interface{}, bool) {
+	srcMap, ok := src.(map[string]interface{})
+	baseMap, baseOk := base.(map[string]interface{})
+	if !ok || !baseOk {
+		return src, !reflect.DeepEqual(src, base)
+	}
+	overlay := make(map[string]interface{})
+	for k, v := range srcMap {
+		if o, ok := overlayOf(v, baseMap[k]); ok {
+			overlay[k] = o
+		}
+	}
+	return overlay, len(overlay) > 0
+}
+
+//deepMerge merge src into dist, the maps are merged by key, the items of itemsDocument are merged by index,
+//other values are replaced
+func deepMerge(dist, src interface{}) interface{} {
+	if items, ok := src.(itemsDocument); ok {
+		return mergeItems(dist, items)
+	}
+	distMap, ok := dist.(map[string]interface{})
+	srcMap, srcOk := src.(map[string]interface{})
+	if !ok || !srcOk {
+		return src
+	}
+	for k, v := range srcMap {
+		distMap[k] = deepMerge(distMap[k], v)
+	}
+	return distMap
+}
+
+//mergeItems merge the items into the slice or the itemsDocument of dist by index, dist is not changed
+func mergeItems(dist interface{}, items itemsDocument) interface{} {
+	if distItems, ok := dist.(itemsDocument); ok {
+		merged := make(itemsDocument, len(distItems)+len(items))
+		for i, v := range distItems {
+			merged[i] = v
+		}
+		for i, v := range items {
+			merged[i] = deepMerge(merged[i], v)
+		}
+		return merged
+	}
+	distSlice, _ := dist.([]interface{})
+	merged := append([]interface{}{}, distSlice...)
+	for i, v := range items {
+		for len(merged) <= i {
+			merged = append(merged, nil)
+		}
+		merged[i] = deepMerge(merged[i], v)
+	}
+	return merged
+}
+
+func cloneMap(src interface{}) interface{} {
+	if items, ok := src.(itemsDocument); ok {
+		dist := make(itemsDocument, len(items))
+		for i, v := range items {
+			dist[i] = cloneMap(v)
+		}
+		return dist
+	}
+	m, ok := src.(map[string]interface{})
+	if !ok {
+		return src
+	}
+	dist := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		dist[k] = cloneMap(v)
+	}
+	return dist
+}
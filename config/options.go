@@ -9,7 +9,9 @@ import (
 
 //Options the Options of config
 type Options struct {
-	URL     string
+	URL string
+	//Sources the urls of layered config, they are merged in order
+	Sources []string
 	Timeout time.Duration
 	//ReloadDelay refresh the config after some time, it used for etcd, consul for the backend may not notify the config
 	ReloadDelay time.Duration
@@ -17,13 +19,77 @@ type Options struct {
 	Watch bool
 	//DefaultConfig default config
 	DefaultConfig interface{}
+	//Validator validate the config before it is applied
+	Validator func(candidate interface{}) error
+	//HistorySize the max number of applied configs kept for History and Rollback
+	HistorySize int
+	//AsyncListeners the size of queue of the dispatcher which calls the listeners, they are called in place if it is 0
+	AsyncListeners int
+	//Revision the revision of config which SaveConfig is based on, it is set by Config.Update, backend returns
+	//ErrConflict if the config it stores is not at this revision, it is 0 if the revision is unknown
+	Revision int64
 	//OnLoaded ! do not set this Manually, this is internal usage
 	OnLoaded OnLoaded
+	//OnError ! do not set this Manually, backend reports the errors of watching by ReportError
+	OnError OnError
+	//OnWatching ! do not set this Manually, backend reports the state of watching by ReportWatching
+	OnWatching OnWatching
+	//Logger the logger of config and backends, use Log to get it in backends
+	Logger Logger
+	//SecretResolvers resolve the secret references in config, no reference is resolved if it is empty
+	SecretResolvers []SecretResolver
+	//Keyring decrypt the encrypted values of config, and encrypt the fields tagged encrypted when they are saved
+	Keyring *Keyring
 	// Other options for implementations of the interface
 	// can be stored in a context
 	Context context.Context
 
 	scheme string
+	//onDocument publish the config with its document, it is set by config for LoadedDocument
+	onDocument func(cfg, doc interface{}) error
+	//overrides the urls of layers which override the sources
+	overrides []string
+}
+
+//NewConfig new a fresh copy of the default config for backend to decode into,
+//backend should never decode into DefaultConfig which is owned by the caller,
+//the fields which are skipped by json are kept, exp: `json:"-"`
+func (o Options) NewConfig() interface{} {
+	return cloneInstance(o.DefaultConfig)
+}
+
+//LoadedDocument publish the config with the document it is decoded from, exp: the map decoded from file,
+//only the keys in the document override the former sources, so a source can set a value back to the default one.
+//The values which differ from the default config override the former sources if the config is published by OnLoaded
+func (o Options) LoadedDocument(cfg, doc interface{}) error {
+	if o.onDocument == nil {
+		return o.OnLoaded(cfg)
+	}
+	return o.onDocument(cfg, doc)
+}
+
+//Log the logger of backend, it is the default logger if no logger is set
+func (o Options) Log() Logger {
+	if o.Logger == nil {
+		return defaultLogger
+	}
+	return o.Logger
+}
+
+//ReportError report the error of backend which happens in background, exp: the watching is broken,
+//it is exposed by Config.Status
+func (o Options) ReportError(err error) {
+	if o.OnError != nil {
+		o.OnError(err)
+	}
+}
+
+//ReportWatching report the backend is watching the changes or not, exp: it is false when the watching is broken,
+//it is exposed by Config.Status
+func (o Options) ReportWatching(watching bool) {
+	if o.OnWatching != nil {
+		o.OnWatching(watching)
+	}
 }
 
 //Option is just Option functions
@@ -34,35 +100,63 @@ type Option func(*Options)
 // exp: file://conf/service.yaml
 func URL(uri string) Option {
 	return func(o *Options) {
-		u, err := url.Parse(uri)
-		if err != nil {
+		setDefaults(o)
+		if err := setURL(o, uri); err != nil {
 			panic(err)
 		}
-		o.URL = uri
-		o.scheme = u.Scheme
-		//default config
-		o.Timeout = 30 * time.Second
-		o.ReloadDelay = time.Hour
-		o.Watch = true
-		if t := u.Query().Get("ttl"); t != "" {
-			if td, err := time.ParseDuration(t); err == nil {
-				o.ReloadDelay = td
-				if td <= 2*time.Second {
-					o.Watch = false
-				}
-			}
-		}
+	}
+}
 
-		if w := u.Query().Get("watch"); w != "" {
-			o.Watch = !(w == "false")
-		}
-		if t := u.Query().Get("timeout"); t != "" {
-			if td, err := time.ParseDuration(t); err == nil {
-				o.Timeout = td
+// Sources is the list of url, the configs loaded from them are merged in order,
+// the latter one overrides the former one
+// exp: Sources("file://conf/service.yaml", "etcd://127.0.0.1:2379/xl/config/key")
+func Sources(uris ...string) Option {
+	return func(o *Options) {
+		setDefaults(o)
+		o.Sources = uris
+	}
+}
+
+//WithEnvOverrides override the fields of config by the environment variables which have the prefix,
+//exp: APP_SERVICES_0_HOOKS_URL overrides Services[0].Hooks.Url when the prefix is APP
+func WithEnvOverrides(prefix string) Option {
+	return func(o *Options) {
+		o.overrides = append(o.overrides, envScheme+"://"+prefix)
+	}
+}
+
+//setDefaults set the default options of url
+func setDefaults(o *Options) {
+	o.Timeout = 30 * time.Second
+	o.ReloadDelay = time.Hour
+	o.Watch = true
+}
+
+//setURL set the url and the options in the query of the url
+func setURL(o *Options, uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	o.URL = uri
+	o.scheme = u.Scheme
+	if t := u.Query().Get("ttl"); t != "" {
+		if td, err := time.ParseDuration(t); err == nil {
+			o.ReloadDelay = td
+			if td <= 2*time.Second {
+				o.Watch = false
 			}
 		}
-		o.scheme = u.Scheme
 	}
+	if w := u.Query().Get("watch"); w != "" {
+		o.Watch = !(w == "false")
+	}
+	if t := u.Query().Get("timeout"); t != "" {
+		if td, err := time.ParseDuration(t); err == nil {
+			o.Timeout = td
+		}
+	}
+	return nil
 }
 
 //WithDefault set default config of the instance
@@ -75,6 +169,29 @@ func WithDefault(defaultConfig interface{}) Option {
 	}
 }
 
+//WithValidator reject the loaded config if validator returns error,
+//the Validate() error method of config is also used if it is implemented
+func WithValidator(validator func(candidate interface{}) error) Option {
+	return func(o *Options) {
+		o.Validator = validator
+	}
+}
+
+//WithHistory keep the last size configs which are applied for History and Rollback
+func WithHistory(size int) Option {
+	return func(o *Options) {
+		o.HistorySize = size
+	}
+}
+
+//WithAsyncListeners call the listeners on a dispatcher goroutine with a queue of size, the reload is never blocked by
+//the listeners, the events are kept in order when the queue is full, so a listener can call Set or Update
+func WithAsyncListeners(size int) Option {
+	return func(o *Options) {
+		o.AsyncListeners = size
+	}
+}
+
 //Timeout load timeout
 func Timeout(t time.Duration) Option {
 	return func(o *Options) {
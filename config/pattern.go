@@ -0,0 +1,188 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+const (
+	//anyField matches a field, an item of slice or a key of map, exp: Services[*].Hooks, DataSource.*
+	anyField = "*"
+	//anyPath matches every path under it, exp: Services.**
+	anyPath = "**"
+)
+
+//isPattern the path has wildcards
+func isPattern(path string) bool {
+	for _, p := range compile(path) {
+		if p == anyField || p == anyPath {
+			return true
+		}
+	}
+	return false
+}
+
+//matchPaths the concrete paths of pattern which are changed by changes, old and new are the configs of changes
+func matchPaths(pattern []string, old, new interface{}, changes []Change) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	for _, change := range changes {
+		tokens := compile(change.Path)
+		n, rest, ok := matchTokens(pattern, tokens, 0)
+		if !ok {
+			continue
+		}
+		if rest == nil {
+			add(pathPrefix(change.Path, n))
+			continue
+		}
+		//the change is a parent of pattern, the paths under it are expanded by the rest of pattern
+		var expanded []string
+		for _, src := range []interface{}{old, new} {
+			v, err := getFieldValueReflect(reflect.ValueOf(src), tokens)
+			if err == nil {
+				expandPattern(v, change.Path, rest, &expanded)
+			}
+		}
+		sort.Strings(expanded)
+		for _, path := range expanded {
+			add(path)
+		}
+	}
+	return paths
+}
+
+//matchTokens match the tokens of path by pattern, it returns the number of tokens matched,
+//rest is the pattern which is not matched when all the tokens are matched by a prefix of pattern
+func matchTokens(pattern, tokens []string, n int) (int, []string, bool) {
+	switch {
+	case len(pattern) == 0:
+		return n, nil, true
+	case len(tokens) == 0:
+		return n, pattern, true
+	case pattern[0] == anyPath:
+		if len(pattern) == 1 {
+			return n + len(tokens), nil, true
+		}
+		for i := 0; i <= len(tokens); i++ {
+			if m, rest, ok := matchTokens(pattern[1:], tokens[i:], n+i); ok {
+				return m, rest, ok
+			}
+		}
+		return 0, nil, false
+	case pattern[0] == anyField || pattern[0] == tokens[0]:
+		return matchTokens(pattern[1:], tokens[1:], n+1)
+	}
+	return 0, nil, false
+}
+
+//expandPattern add the concrete paths under v which match pattern
+func expandPattern(v reflect.Value, path string, pattern []string, paths *[]string) {
+	if len(pattern) == 0 {
+		*paths = append(*paths, path)
+		return
+	}
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return
+	}
+	if pattern[0] == anyPath {
+		if len(pattern) == 1 && !hasChildren(v) {
+			*paths = append(*paths, path)
+			return
+		}
+		if len(pattern) > 1 {
+			expandPattern(v, path, pattern[1:], paths)
+		}
+		eachChild(v, path, func(child reflect.Value, childPath string) {
+			expandPattern(child, childPath, pattern, paths)
+		})
+		return
+	}
+	eachChild(v, path, func(child reflect.Value, childPath string) {
+		if pattern[0] == anyField || pattern[0] == lastToken(childPath) {
+			expandPattern(child, childPath, pattern[1:], paths)
+		}
+	})
+}
+
+//eachChild call fn with the fields of struct, the items of slice and the values of map in order
+func eachChild(v reflect.Value, path string, fn func(child reflect.Value, childPath string)) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if f.Anonymous {
+				//the fields of embedded struct are promoted
+				eachChild(reflect.Indirect(v.Field(i)), path, fn)
+				continue
+			}
+			fn(v.Field(i), joinPath(path, f.Name))
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fn(v.Index(i), path+"["+strconv.Itoa(i)+"]")
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		for _, k := range mapKeys(v, v) {
+			fn(v.MapIndex(k), joinPath(path, k.String()))
+		}
+	}
+}
+
+func hasChildren(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Struct:
+		return v.Type() != durationType && hasExportedField(v.Type())
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return false
+}
+
+//pathPrefix the path of the first n tokens of path, exp: pathPrefix("Services[0].Hooks.Url", 3) is Services[0].Hooks
+func pathPrefix(path string, n int) string {
+	tokens := compile(path)
+	if n >= len(tokens) {
+		return path
+	}
+	var end int
+	for i := 0; i < n; i++ {
+		//skip the separator and the token
+		if end > 0 && path[end] == ']' {
+			end++
+		}
+		if end > 0 {
+			end++
+		}
+		end += len(tokens[i])
+	}
+	if end < len(path) && path[end] == ']' {
+		end++
+	}
+	return path[:end]
+}
+
+func lastToken(path string) string {
+	tokens := compile(path)
+	if len(tokens) == 0 {
+		return ""
+	}
+	return tokens[len(tokens)-1]
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPatternListener(t *testing.T) {
+	c := New(&TestKV{})
+	matched := make(map[string][]string)
+	for _, pattern := range []string{"Services[*].Hooks", "DataSource.*", "Services.**"} {
+		pattern := pattern
+		c.SetPatternListener(pattern, func(path string, pre, current interface{}) {
+			matched[pattern] = append(matched[pattern], path)
+		})
+	}
+	var hooks []interface{}
+	c.SetFieldListener("Services[*].Hooks.Url", func(pre, current interface{}) {
+		hooks = append(hooks, current)
+	})
+	initCas(t, c, nil)
+	//every path is added by the first config
+	expect := map[string][]string{
+		"Services[*].Hooks": {"Services[0].Hooks", "Services[1].Hooks"},
+		"DataSource.*":      {"DataSource.cache", "DataSource.sql"},
+	}
+	if len(matched["Services.**"]) != 8 || !reflect.DeepEqual(matched["DataSource.*"], expect["DataSource.*"]) ||
+		!reflect.DeepEqual(matched["Services[*].Hooks"], expect["Services[*].Hooks"]) {
+		t.Fatalf("matched %v does not match expect %v", matched, expect)
+	}
+	matched = make(map[string][]string)
+	err := c.Update(func(cfg interface{}) error {
+		kv := cfg.(*TestKV)
+		kv.Services[1].Hooks.Url = "http://hooks"
+		kv.DataSource["redis"] = "redis://127.0.0.1"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = map[string][]string{
+		"Services[*].Hooks": {"Services[1].Hooks"},
+		"DataSource.*":      {"DataSource.redis"},
+		"Services.**":       {"Services[1].Hooks.Url"},
+	}
+	if !reflect.DeepEqual(matched, expect) {
+		t.Fatalf("matched %v does not match expect %v", matched, expect)
+	}
+	if len(hooks) != 3 || hooks[2] != "http://hooks" {
+		t.Fatalf("hooks %v does not match expect", hooks)
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	for path, expect := range map[string]string{
+		"Services[0].Hooks.Url": "Services[0].Hooks",
+		"a[0][1].b":             "a[0][1]",
+		"DataSource.sql":        "DataSource.sql",
+	} {
+		if prefix := pathPrefix(path, 3); prefix != expect {
+			t.Fatalf("prefix of %s is %s, expect %s", path, prefix, expect)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//secretMask the value of secret when the config is masked
+const secretMask = "******"
+
+//SecretResolver resolve the secret references in the string values of config, exp: secret://file/run/secrets/db,
+//the references in ${} are resolved with the content in it, exp: ${env:DB_PASS} is resolved with env:DB_PASS
+type SecretResolver interface {
+	//Resolve get the secret of ref, ok is false if the ref is not supported by the resolver
+	Resolve(ref string) (secret string, ok bool, err error)
+}
+
+//SecretResolverFunc the func which implements SecretResolver
+type SecretResolverFunc func(ref string) (secret string, ok bool, err error)
+
+//Resolve call the func
+func (f SecretResolverFunc) Resolve(ref string) (string, bool, error) {
+	return f(ref)
+}
+
+//FileSecretResolver resolve the references of the files in root, exp: secret://file/run/secrets/db is the content
+//of /run/secrets/db, the files out of root are rejected even if they are linked by symlinks in root
+func FileSecretResolver(root string) SecretResolver {
+	return SecretResolverFunc(func(ref string) (string, bool, error) {
+		if !strings.HasPrefix(ref, "secret://file/") {
+			return "", false, nil
+		}
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", true, err
+		}
+		path, err := filepath.EvalSymlinks(u.Path)
+		if err != nil {
+			return "", true, err
+		}
+		dir, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return "", true, err
+		}
+		if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", true, fmt.Errorf("secret file %s is not in %s", u.Path, root)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", true, err
+		}
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	})
+}
+
+//EnvSecretResolver resolve the references of environment variables, exp: ${env:DB_PASS}
+var EnvSecretResolver SecretResolver = SecretResolverFunc(func(ref string) (string, bool, error) {
+	if !strings.HasPrefix(ref, "env:") {
+		return "", false, nil
+	}
+	name := strings.TrimPrefix(ref, "env:")
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", true, fmt.Errorf("env %s is not set", name)
+	}
+	return value, true, nil
+})
+
+//WithSecretResolvers add the resolvers of secret references in order, the built-in resolvers are not used by default,
+//exp: WithSecretResolvers(FileSecretResolver("/run/secrets"), EnvSecretResolver)
+func WithSecretResolvers(resolvers ...SecretResolver) Option {
+	return func(o *Options) {
+		o.SecretResolvers = append(o.SecretResolvers, resolvers...)
+	}
+}
+
+//embeddedRef the references in a string, exp: mysql://user:${env:DB_PASS}@127.0.0.1:3306/db
+var embeddedRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+//resolveSecrets resolve the secret references in a copy of cfg, it returns the paths of the values which are resolved
+func resolveSecrets(cfg interface{}, resolvers []SecretResolver) (interface{}, map[string]bool, error) {
+	if cfg == nil {
+		return cfg, nil, nil
+	}
+	resolved := cloneInstance(cfg)
+	secrets := make(map[string]bool)
+	v := reflect.ValueOf(resolved)
+	if v.Kind() != reflect.Ptr {
+		//the copy of value is addressable by a pointer
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+	err := walkFields(v, "", false, func(path, s string, encrypted bool) (string, error) {
+		if encrypted && s != "" && !IsEncrypted(s) {
+			return "", fmt.Errorf("field %s is tagged encrypted, but its value is not encrypted", path)
+		}
+		secret, ok, err := resolveRef(s, resolvers)
+		if err != nil {
+			return "", fmt.Errorf("secret of %s error for %s", path, err)
+		}
+		if ok {
+			secrets[path] = true
+			return secret, nil
+		}
+		if encrypted && s != "" {
+			return "", fmt.Errorf("field %s is encrypted, but the config has no keyring", path)
+		}
+		var refErr error
+		replaced := embeddedRef.ReplaceAllStringFunc(s, func(ref string) string {
+			secret, ok, err := resolveRef(ref[2:len(ref)-1], resolvers)
+			if err != nil && refErr == nil {
+				refErr = fmt.Errorf("secret of %s error for %s", path, err)
+			}
+			if !ok || err != nil {
+				return ref
+			}
+			secrets[path] = true
+			return secret
+		})
+		return replaced, refErr
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if reflect.ValueOf(resolved).Kind() != reflect.Ptr {
+		resolved = v.Elem().Interface()
+	}
+	return resolved, secrets, nil
+}
+
+func resolveRef(ref string, resolvers []SecretResolver) (string, bool, error) {
+	for _, r := range resolvers {
+		if secret, ok, err := r.Resolve(ref); ok || err != nil {
+			return secret, ok, err
+		}
+	}
+	return "", false, nil
+}
+
+//maskSecrets a copy of value in path whose secrets are masked
+func maskSecrets(value interface{}, path string, secrets map[string]bool) interface{} {
+	if len(secrets) == 0 || value == nil {
+		return value
+	}
+	var under bool
+	for secret := range secrets {
+		if isUnder(path, secret) {
+			return secretMask
+		}
+		if isUnder(secret, path) {
+			under = true
+		}
+	}
+	if !under {
+		return value
+	}
+	masked := cloneInstance(value)
+	v := reflect.ValueOf(masked)
+	if v.Kind() != reflect.Ptr {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+	walkStrings(v, path, func(p, s string) (string, error) {
+		if secrets[p] {
+			return secretMask, nil
+		}
+		return s, nil
+	})
+	if reflect.ValueOf(masked).Kind() != reflect.Ptr {
+		return v.Elem().Interface()
+	}
+	return masked
+}
+
+//maskChanges a copy of changes whose secrets of pre and s are masked
+func maskChanges(changes []Change, pre, s *snapshot) []Change {
+	secrets := make(map[string]bool)
+	for _, snap := range []*snapshot{pre, s} {
+		if snap == nil {
+			continue
+		}
+		for path := range snap.secrets {
+			secrets[path] = true
+		}
+	}
+	if len(secrets) == 0 {
+		return changes
+	}
+	masked := make([]Change, len(changes))
+	for i, change := range changes {
+		change.Old = maskSecrets(change.Old, change.Path, secrets)
+		change.New = maskSecrets(change.New, change.Path, secrets)
+		masked[i] = change
+	}
+	return masked
+}
+
+//isUnder the path is the parent path or the same one
+func isUnder(path, parent string) bool {
+	return parent == "" || path == parent || strings.HasPrefix(path, parent+".") || strings.HasPrefix(path, parent+"[")
+}
+
+//Masked get a copy of the current config whose secrets are masked, it can be logged
+func (c *Config) Masked() interface{} {
+	s, ok := c.current.Load().(*snapshot)
+	if !ok {
+		return c.Snapshot()
+	}
+	return maskSecrets(s.cfg, "", s.secrets)
+}
+
+//walkStrings replace every string in v by the result of fn with the path of it
+func walkStrings(v reflect.Value, path string, fn func(path, s string) (string, error)) error {
+	return walkFields(v, path, false, func(path, s string, encrypted bool) (string, error) {
+		return fn(path, s)
+	})
+}
+
+//walkFields replace every string in v by the result of fn, encrypted is true if the string is in
+//a field tagged by `config:"name,encrypted"`
+func walkFields(v reflect.Value, path string, encrypted bool, fn func(path, s string, encrypted bool) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return walkFields(v.Elem(), path, encrypted, fn)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		//the value in interface is not addressable, it is set back
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := walkFields(elem, path, encrypted, fn); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.String:
+		s, err := fn(path, v.String(), encrypted)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			fieldPath := joinPath(path, f.Name)
+			if f.Anonymous {
+				fieldPath = path
+			}
+			if err := walkFields(v.Field(i), fieldPath, encrypted || isEncryptedField(f), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkFields(v.Index(i), path+"["+strconv.Itoa(i)+"]", encrypted, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		for _, k := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(k))
+			if err := walkFields(elem, joinPath(path, k.String()), encrypted, fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, elem)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//Status the status of config and its sources
+type Status struct {
+	//Healthy every source is loaded after its last error
+	Healthy  bool           `json:"healthy"`
+	Version  int            `json:"version"`
+	Revision int64          `json:"revision"`
+	Sources  []SourceStatus `json:"sources"`
+}
+
+//SourceStatus the status of a source of config
+type SourceStatus struct {
+	//URL the url of source without password
+	URL    string `json:"url"`
+	Scheme string `json:"scheme"`
+	//Watching the backend is watching the changes now, it is false if the watching is broken or not supported
+	Watching   bool      `json:"watching"`
+	Polling    bool      `json:"polling"`
+	Revision   int64     `json:"revision"`
+	LastLoaded time.Time `json:"last_loaded"`
+	LastError  string    `json:"last_error,omitempty"`
+	//LastErrorTime it is nil if the source has no error
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+//Status get the status of the sources of the last Init, it is not healthy if the config is stale
+func (c *Config) Status() Status {
+	status := Status{Healthy: true, Revision: c.Revision()}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status.Version = c.version
+	for _, l := range c.layers {
+		s := SourceStatus{
+			URL:        redact(l.options.URL),
+			Scheme:     l.options.scheme,
+			Watching:   l.watching,
+			Polling:    l.options.ReloadDelay > time.Second,
+			Revision:   l.revision,
+			LastLoaded: l.loadedAt,
+		}
+		if l.err != nil {
+			errAt := l.errAt
+			s.LastError, s.LastErrorTime = l.err.Error(), &errAt
+		}
+		if l.loadedAt.IsZero() || l.errAt.After(l.loadedAt) {
+			status.Healthy = false
+		}
+		status.Sources = append(status.Sources, s)
+	}
+	return status
+}
+
+//StatusHandler the http handler which writes the Status as json, the status code is 503 if it is not healthy,
+//exp: mux.HandleHTTP("GET", "/config/status", cfg.StatusHandler())
+func (c *Config) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := c.Status()
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+//layerError record the error of layer for Status
+func (c *Config) layerError(l *layer, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l.err, l.errAt = err, time.Now()
+}
+
+func redact(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
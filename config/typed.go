@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+//Typed the type safe handle of a Config which config type is T
+type Typed[T any] struct {
+	*Config
+}
+
+//NewTyped new typed config use default config for config
+func NewTyped[T any](defaultConfig *T) *Typed[T] {
+	return &Typed[T]{Config: New(defaultConfig)}
+}
+
+//Init init config by url, the default config must be a *T
+func (t *Typed[T]) Init(opts ...Option) error {
+	var options Options
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.DefaultConfig != nil {
+		if _, ok := options.DefaultConfig.(*T); !ok {
+			return fmt.Errorf("default config %T is not a %T", options.DefaultConfig, (*T)(nil))
+		}
+	}
+	return t.Config.Init(opts...)
+}
+
+//Get get the current config
+func (t *Typed[T]) Get() T {
+	var v T
+	switch cfg := t.GetConfig().(type) {
+	case *T:
+		if cfg != nil {
+			v = *cfg
+		}
+	case T:
+		v = cfg
+	}
+	return v
+}
+
+//OnField bind a typed trigger when the field of path is changed,
+//the path and the type F are checked against T before the trigger is bound, the trigger is unbound by Cancel of the subscription
+func OnField[T, F any](t *Typed[T], path string, onChange func(old, new F)) (*Subscription, error) {
+	fieldType, err := getFieldType(reflect.TypeOf((*T)(nil)).Elem(), compile(path))
+	if err != nil {
+		return nil, fmt.Errorf("path %s error for %s", path, err)
+	}
+	if want := reflect.TypeOf((*F)(nil)).Elem(); !fieldType.AssignableTo(want) {
+		return nil, fmt.Errorf("path %s is %s, can not be used as %s", path, fieldType, want)
+	}
+	return t.SetFieldListener(path, func(pre, current interface{}) {
+		old, _ := pre.(F)
+		n, _ := current.(F)
+		onChange(old, n)
+	}), nil
+}
+
+//getFieldType get the type of path, it walks like getFieldValueReflect
+func getFieldType(src reflect.Type, paths []string) (reflect.Type, error) {
+	if len(paths) == 0 {
+		return src, nil
+	}
+	key := paths[0]
+	switch k := src.Kind(); k {
+	case reflect.Map:
+		if src.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key %s is not supported", src.Key())
+		}
+		return getFieldType(src.Elem(), paths[1:])
+	case reflect.Struct:
+		f, ok := src.FieldByName(key)
+		if !ok {
+			return nil, fmt.Errorf("%s has no field %s", src, key)
+		}
+		return getFieldType(f.Type, paths[1:])
+	case reflect.Slice:
+		if _, en := strconv.Atoi(key); en != nil {
+			return nil, fmt.Errorf("%s is not a number %s", key, en)
+		}
+		return getFieldType(src.Elem(), paths[1:])
+	case reflect.Ptr:
+		return getFieldType(src.Elem(), paths)
+	default:
+		return nil, fmt.Errorf("%s is not supported", k)
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestOnField(t *testing.T) {
+	cfg := testKV
+	typed := NewTyped(&cfg)
+	if _, err := OnField(typed, "Services[0].Hooks.Url", func(old, new int) {}); err == nil {
+		t.Fatal("expect type error for int field")
+	}
+	if _, err := OnField(typed, "Services[0].Hook", func(old, new Hooks) {}); err == nil {
+		t.Fatal("expect path error for unknown field")
+	}
+	var hooks Hooks
+	if _, err := OnField(typed, "Services[0].Hooks", func(old, new Hooks) {
+		hooks = new
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var cache string
+	sub, err := OnField(typed, "DataSource.cache", func(old, new string) {
+		cache = new
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := typed.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if hooks != testKV.Services[0].Hooks {
+		t.Fatalf("hooks %v does not match expect %v", hooks, testKV.Services[0].Hooks)
+	}
+	if cache != testKV.DataSource["cache"] {
+		t.Fatalf("cache %s does not match expect %s", cache, testKV.DataSource["cache"])
+	}
+	if typed.Get().Addr != testKV.Addr {
+		t.Fatalf("addr %s does not match expect %s", typed.Get().Addr, testKV.Addr)
+	}
+	//the canceled trigger is not called by the next change
+	sub.Cancel()
+	changed := cloneInstance(testKV).(TestKV)
+	changed.DataSource["cache"] = "redis://changed"
+	if err := typed.Init(WithDefault(&changed)); err != nil {
+		t.Fatal(err)
+	}
+	if typed.Get().DataSource["cache"] != "redis://changed" || cache != testKV.DataSource["cache"] {
+		t.Fatalf("cache %s should not be changed after cancel", cache)
+	}
+}
+
+func TestTypedInit(t *testing.T) {
+	typed := NewTyped(&TestKV{})
+	if err := typed.Init(WithDefault(&Hooks{})); err == nil {
+		t.Fatal("expect error for default config of other type")
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"context"
+	"sync"
+)
+
+//Watch receive the changes of path from the channel, the path can be a pattern of SetPatternListener,
+//only the latest change of every path is kept when the receiver is slow, the changes of different paths
+//are all delivered in order. The channel is closed when ctx is done or the config is closed
+func (c *Config) Watch(ctx context.Context, path string) <-chan Change {
+	ch := make(chan Change)
+	signal := make(chan struct{}, 1)
+	var mu sync.Mutex
+	//order the paths of the changes which are not sent, latest is the latest change of them
+	var order []string
+	latest := make(map[string]Change)
+	sub := c.addListener(&listener{path: path, pattern: compile(path), onMatch: func(path string, pre, current interface{}) {
+		change := Change{Path: path, Old: pre, New: current, Kind: ChangeModified}
+		if pre == nil {
+			change.Kind = ChangeAdded
+		} else if current == nil {
+			change.Kind = ChangeRemoved
+		}
+		mu.Lock()
+		if _, ok := latest[path]; !ok {
+			order = append(order, path)
+		}
+		latest[path] = change
+		mu.Unlock()
+		select {
+		case signal <- struct{}{}:
+		default:
+		}
+	}})
+	//next take the change of the first path which is not sent
+	next := func() (Change, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(order) == 0 {
+			return Change{}, false
+		}
+		change := latest[order[0]]
+		delete(latest, order[0])
+		order = order[1:]
+		return change, true
+	}
+	//replace take the newer change of the path which is being sent
+	replace := func(change *Change) {
+		mu.Lock()
+		defer mu.Unlock()
+		newer, ok := latest[change.Path]
+		if !ok {
+			return
+		}
+		*change = newer
+		delete(latest, change.Path)
+		for i, p := range order {
+			if p == change.Path {
+				order = append(order[:i:i], order[i+1:]...)
+				break
+			}
+		}
+	}
+	done := c.closing()
+	go func() {
+		defer close(ch)
+		defer sub.Cancel()
+		for {
+			change, ok := next()
+			if !ok {
+				select {
+				case <-signal:
+					continue
+				case <-ctx.Done():
+					return
+				case <-done:
+					return
+				}
+			}
+			for sent := false; !sent; {
+				select {
+				case ch <- change:
+					sent = true
+				case <-signal:
+					replace(&change)
+				case <-ctx.Done():
+					return
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+//closing the channel which is closed by the next Close
+func (c *Config) closing() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed == nil {
+		c.closed = make(chan struct{})
+	}
+	return c.closed
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+//ErrNoWriter none of the backends of config can be written
+var ErrNoWriter = errors.New("config has no backend which can be written")
+
+//ErrConflict the config in backend is modified after it is loaded, the write is discarded,
+//reload the config and try again
+type ErrConflict struct {
+	//Path the path of config in backend
+	Path string
+	//Revision the revision of config which the write is based on
+	Revision int64
+}
+
+func (e *ErrConflict) Error() string {
+	return fmt.Sprintf("config %s is modified after revision %d", e.Path, e.Revision)
+}
+
+//IsConflict the error is caused by ErrConflict
+func IsConflict(err error) bool {
+	var conflict *ErrConflict
+	return errors.As(err, &conflict)
+}
+
+//Set set the value of field in path, and save the config to backend, exp: Set("Services[0].Hooks.Url", "http://hooks")
+func (c *Config) Set(path string, value interface{}) error {
+	return c.Update(func(cfg interface{}) error {
+		return setPath(reflect.ValueOf(cfg), compile(path), func(field reflect.Value) error {
+			v := reflect.ValueOf(value)
+			if !v.IsValid() {
+				field.Set(reflect.Zero(field.Type()))
+				return nil
+			}
+			if !v.Type().AssignableTo(field.Type()) {
+				if !v.Type().ConvertibleTo(field.Type()) || v.Kind() != field.Kind() {
+					return fmt.Errorf("path %s is %s, can not be set by %s", path, field.Type(), v.Type())
+				}
+				v = v.Convert(field.Type())
+			}
+			field.Set(v)
+			return nil
+		})
+	})
+}
+
+//Update change a copy of config by update, and save the config to backend,
+//the config is the one loaded by the last source whose backend implements Writer,
+//which is the full config when there is only one source
+func (c *Config) Update(update func(cfg interface{}) error) error {
+	c.updateMu.Lock()
+	//the listeners called in place are delivered after updateMu is unlocked, so they can call Update
+	held := c.holdDispatch()
+	defer func() {
+		c.updateMu.Unlock()
+		if held {
+			c.deliverPending()
+		}
+	}()
+	c.mu.Lock()
+	target := writable(c.layers)
+	var cfg interface{}
+	var options Options
+	if target != nil {
+		//the revision is taken with the config, the config may be reloaded by watching before it is saved
+		cfg, options = cloneInstance(target.cfg), target.options
+		options.Revision = target.revision
+	}
+	c.mu.Unlock()
+	if target == nil {
+		return ErrNoWriter
+	}
+	if cfg == nil || reflect.ValueOf(cfg).Kind() != reflect.Ptr {
+		return errors.New("config should be a pointer to be updated")
+	}
+	if err := update(cfg); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	keyring := c.keyring
+	c.mu.Unlock()
+	if err := encryptFields(cfg, keyring); err != nil {
+		return err
+	}
+	candidate, err := c.candidate(target, cfg)
+	if err != nil {
+		return err
+	}
+	s, err := c.newSnapshot(candidate)
+	if err != nil {
+		return err
+	}
+	candidate = s.cfg
+	if err := c.validate(candidate); err != nil {
+		return fmt.Errorf("config is rejected by validator: %w", err)
+	}
+	if err := target.backend.(Writer).SaveConfig(options, cfg); err != nil {
+		return err
+	}
+	//publish it now, the watching of backend may be disabled, every key of the config is saved
+	doc, err := toMap(cfg)
+	if err != nil {
+		return err
+	}
+	return target.options.LoadedDocument(cfg, doc)
+}
+
+//writable the layer of the last source whose backend implements Writer, it is nil if there is none
+func writable(layers []*layer) *layer {
+	var target *layer
+	for _, l := range layers {
+		if _, ok := l.backend.(Writer); ok {
+			target = l
+		}
+	}
+	return target
+}
+
+//candidate the merged config if the config of target is cfg
+func (c *Config) candidate(target *layer, cfg interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	layers := make([]*layer, len(c.layers))
+	for i, l := range c.layers {
+		if l == target {
+			doc, err := toMap(cfg)
+			if err != nil {
+				return nil, err
+			}
+			l = &layer{options: l.options, backend: l.backend, cfg: cfg, doc: doc}
+		}
+		layers[i] = l
+	}
+	merged, _, err := mergeLayers(target.options.DefaultConfig, layers)
+	if err != nil {
+		return nil, err
+	}
+	return applyFlags(merged, c.flags)
+}
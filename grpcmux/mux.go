@@ -46,6 +46,14 @@ func (s *ServeMux) Handle(method string, path string, h runtime.HandlerFunc) {
 	}
 }
 
+// HandleHTTP associates the http handler "h" to the pair of HTTP method and path pattern,
+// exp: mux.HandleHTTP("GET", "/config/status", config.StandardConfig().StatusHandler())
+func (s *ServeMux) HandleHTTP(method string, path string, h http.Handler) {
+	s.Handle(method, path, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		h.ServeHTTP(w, r)
+	})
+}
+
 //MuxedGrpc check the context is by mux grpc
 type MuxedGrpc struct {
 }